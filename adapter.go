@@ -0,0 +1,31 @@
+package cache
+
+// Cache is a minimal key-value cache interface, to swap implementations in tests.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Remove(key string)
+}
+
+// adapter implements Cache on GigaCache.
+type adapter struct {
+	c *GigaCache
+}
+
+// AsCache returns the cache as a Cache.
+func (c *GigaCache) AsCache() Cache {
+	return adapter{c}
+}
+
+func (a adapter) Get(key string) ([]byte, bool) {
+	value, _, ok := a.c.Get(key)
+	return value, ok
+}
+
+func (a adapter) Set(key string, value []byte) {
+	a.c.Set(key, value)
+}
+
+func (a adapter) Remove(key string) {
+	a.c.Remove(key)
+}
@@ -0,0 +1,31 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mapCache is a Cache backed by builtin map.
+type mapCache map[string][]byte
+
+func (m mapCache) Get(key string) ([]byte, bool) { v, ok := m[key]; return v, ok }
+func (m mapCache) Set(key string, value []byte)  { m[key] = value }
+func (m mapCache) Remove(key string)             { delete(m, key) }
+
+func TestAsCache(t *testing.T) {
+	assert := assert.New(t)
+	for _, c := range []Cache{New(DefaultOptions).AsCache(), mapCache{}} {
+		_, ok := c.Get("foo")
+		assert.False(ok)
+
+		c.Set("foo", []byte("bar"))
+		val, ok := c.Get("foo")
+		assert.True(ok)
+		assert.Equal(val, []byte("bar"))
+
+		c.Remove("foo")
+		_, ok = c.Get("foo")
+		assert.False(ok)
+	}
+}
@@ -53,6 +53,44 @@ func BenchmarkSet(b *testing.B) {
 			m.Set(k, v)
 		}
 	})
+	b.Run("cache/indexMap", func(b *testing.B) {
+		options := DefaultOptions
+		options.IndexKind = IndexMap
+		m := New(options)
+		for i := 0; i < b.N; i++ {
+			k, v := genKV(i)
+			m.Set(k, v)
+		}
+	})
+	b.Run("cache/growStep", func(b *testing.B) {
+		options := DefaultOptions
+		options.BufferGrowStep = 256 * KB
+		m := New(options)
+		for i := 0; i < b.N; i++ {
+			k, v := genKV(i)
+			m.Set(k, v)
+		}
+	})
+}
+
+func BenchmarkSetParallel(b *testing.B) {
+	for _, kind := range []LockKind{LockRWMutex, LockMutex} {
+		name := "rwmutex"
+		if kind == LockMutex {
+			name = "mutex"
+		}
+		b.Run(name, func(b *testing.B) {
+			options := DefaultOptions
+			options.LockKind = kind
+			m := New(options)
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					k, v := genKV(i)
+					m.Set(k, v)
+				}
+			})
+		})
+	}
 }
 
 func BenchmarkGet(b *testing.B) {
@@ -129,4 +167,13 @@ func BenchmarkMigrate(b *testing.B) {
 			m.Migrate()
 		}
 	})
+	b.Run("cache/reuseBuffer", func(b *testing.B) {
+		options := DefaultOptions
+		options.ReuseMigrateBuffer = true
+		m := getCache(100000, options)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			m.Migrate()
+		}
+	})
 }
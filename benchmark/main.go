@@ -54,6 +54,24 @@ func main() {
 			cache.Set(k, v)
 		}
 
+	case "cache-growstep":
+		options := cache.DefaultOptions
+		options.BufferGrowStep = 256 * cache.KB
+		cache := cache.New(options)
+		for i := 0; i < entries; i++ {
+			k, v := genKV(i)
+			cache.Set(k, v)
+		}
+
+	case "cache-reusemigrate":
+		options := cache.DefaultOptions
+		options.ReuseMigrateBuffer = true
+		cache := cache.New(options)
+		for i := 0; i < entries; i++ {
+			k, v := genKV(i)
+			cache.Set(k, v)
+		}
+
 	case "stdmap":
 		m := make(map[string][]byte)
 		for i := 0; i < entries; i++ {
@@ -1,31 +1,53 @@
 package cache
 
 import (
+	"bytes"
 	"encoding/binary"
+	"math/rand/v2"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/cockroachdb/swiss"
 	"github.com/zeebo/xxh3"
 )
 
 // bucket is the data container for GigaCache.
 type bucket struct {
 	rwlocker
+	id      int
 	options *Options
 
 	// index maps hashed keys to their storage positions in data.
-	index *swiss.Map[Key, Idx]
+	index indexStore
 
 	// data stores all key-value bytes data.
 	data []byte
 
+	// spare is the previous data reused by migrate if ReuseMigrateBuffer is enabled.
+	spare []byte
+
+	// layout is increased whenever entries are relocated, which invalidates positions in data.
+	layout uint64
+
+	// scanners is the number of ScanChunked in progress on the bucket, which postpone
+	// migrations by migrateIfNeeded so that their cursors stay valid.
+	scanners atomic.Int32
+
 	// runtime statistics
 	interval   int
-	unused     uint32
+	peakAlloc  int
+	unused     uint64
 	migrations uint32
 	evictions  uint64
+	capEvicts  uint64 // part of evictions of alive keys to make room.
 	probes     uint64
+	dropped    uint64
+	padded     uint64
+	rejections uint64
+
+	// checksumFailures is updated by reads under the read lock.
+	checksumFailures atomic.Uint64
 }
 
 type rwlocker interface {
@@ -45,84 +67,383 @@ func (emptyLocker) RLock() {}
 
 func (emptyLocker) RUnlock() {}
 
+// mutexLocker is a Mutex that takes the full lock for reads.
+type mutexLocker struct {
+	sync.Mutex
+}
+
+func (l *mutexLocker) RLock() { l.Lock() }
+
+func (l *mutexLocker) RUnlock() { l.Unlock() }
+
+// timedLocker wraps a rwlocker and accumulates the time spent waiting for the lock.
+type timedLocker struct {
+	rwlocker
+	waitNanos atomic.Int64
+}
+
+func (l *timedLocker) Lock() {
+	start := time.Now()
+	l.rwlocker.Lock()
+	l.waitNanos.Add(int64(time.Since(start)))
+}
+
+func (l *timedLocker) RLock() {
+	start := time.Now()
+	l.rwlocker.RLock()
+	l.waitNanos.Add(int64(time.Since(start)))
+}
+
 // newBucket initializes and returns a new bucket instance.
-func newBucket(options Options) *bucket {
+func newBucket(id int, options Options) *bucket {
 	bucket := &bucket{
 		rwlocker: &emptyLocker{},
+		id:       id,
 		options:  &options,
-		index:    swiss.New[Key, Idx](options.IndexSize),
+		index:    newIndex(options.IndexKind, options.indexSize()),
 		data:     make([]byte, 0, options.BufferSize),
 	}
-	if options.ConcurrencySafe {
+	switch options.lockKind() {
+	case LockMutex:
+		bucket.rwlocker = &mutexLocker{}
+	case LockRWMutex:
 		bucket.rwlocker = &sync.RWMutex{}
+	default:
+		return bucket
+	}
+	if options.TrackLockWait {
+		bucket.rwlocker = &timedLocker{rwlocker: bucket.rwlocker}
 	}
 	return bucket
 }
 
+// resetStats resets the counters of runtime statistics.
+func (b *bucket) resetStats() {
+	b.migrations = 0
+	b.evictions = 0
+	b.capEvicts = 0
+	b.probes = 0
+	b.dropped = 0
+	b.rejections = 0
+	b.checksumFailures.Store(0)
+	if l, ok := b.rwlocker.(*timedLocker); ok {
+		l.waitNanos.Store(0)
+	}
+}
+
+// lockWaitNanos returns the accumulated lock waiting time if TrackLockWait is enabled.
+func (b *bucket) lockWaitNanos() int64 {
+	if l, ok := b.rwlocker.(*timedLocker); ok {
+		return l.waitNanos.Load()
+	}
+	return 0
+}
+
 func hashFn(kstr string) Key {
 	return xxh3.HashString128(kstr)
 }
 
 // get retrieves the value and its expiration time for the given key string.
-func (b *bucket) get(key Key) ([]byte, int64, bool) {
-	idx, found := b.index.Get(key)
-	if found && !idx.expired() {
-		_, _, val := b.findEntry(idx)
+func (b *bucket) get(key Key, keyStr []byte) ([]byte, int64, bool) {
+	idx, val, found := b.find(key, keyStr)
+	if found {
 		return val, idx.lo, found
 	}
 
 	return nil, 0, false
 }
 
+// hasExpired reports whether the key is expired but not yet evicted.
+func (b *bucket) hasExpired(key Key) bool {
+	idx, found := b.index.Get(key)
+	return found && idx.expired()
+}
+
+// find retrieves the alive index and value for the given key, tombstones are not found.
+func (b *bucket) find(key Key, keyStr []byte) (Idx, []byte, bool) {
+	idx, val, found := b.lookup(key, keyStr)
+	if found && b.isMiss(idx) {
+		return Idx{}, nil, false
+	}
+	return idx, val, found
+}
+
+// lookup is like find, but also returns tombstones.
+func (b *bucket) lookup(key Key, keyStr []byte) (Idx, []byte, bool) {
+	idx, val, found := b.peek(key, keyStr)
+	if found && idx.expired() {
+		return Idx{}, nil, false
+	}
+	return idx, val, found
+}
+
+// peek is like lookup, but also returns expired entries not yet evicted.
+// The stored key is compared with keyStr if VerifyKeys is enabled and keyStr is not nil.
+func (b *bucket) peek(key Key, keyStr []byte) (Idx, []byte, bool) {
+	idx, found := b.index.Get(key)
+	if !found {
+		return Idx{}, nil, false
+	}
+	_, kstr, val := b.findEntry(idx)
+	if !b.keyMatches(kstr, keyStr) {
+		return Idx{}, nil, false
+	}
+	if b.corrupted(idx) {
+		return Idx{}, nil, false
+	}
+	val, ok := b.decode(idx, val)
+	return idx, val, ok
+}
+
+// keyMatches reports whether the stored key kstr is keyStr, it is always true
+// if VerifyKeys is disabled or keyStr is nil.
+func (b *bucket) keyMatches(kstr, keyStr []byte) bool {
+	return !b.options.VerifyKeys || keyStr == nil || bytes.Equal(kstr, keyStr)
+}
+
 // set stores the key-value pair into the bucket with an expiration timestamp.
-func (b *bucket) set(key Key, keyStr, val []byte, ts int64) (newField bool) {
+// It returns ErrRejected if the key is rejected by OnHashConflict or FullReject.
+func (b *bucket) set(key Key, keyStr, val []byte, ts int64) (newField bool, err error) {
+	val, flags := b.encode(val)
+	return b.put(key, keyStr, val, ts, flags)
+}
+
+// setVersioned stores the key-value pair with a version if it is greater than the version
+// of the alive key, and reports whether it is stored.
+func (b *bucket) setVersioned(key Key, keyStr, val []byte, ts int64, version uint64) bool {
+	if idx, _, found := b.lookup(key, keyStr); found && b.version(idx) >= version {
+		return false
+	}
+	if _, err := b.set(key, keyStr, val, ts); err != nil {
+		return false
+	}
+	idx, _ := b.index.Get(key)
+	b.putVersion(idx, version)
+	if b.options.VerifyChecksum {
+		b.putChecksum(idx)
+	}
+	return true
+}
+
+// restore stores the key-value pair loaded by load with the header kept by save,
+// fields not enabled by options are ignored.
+func (b *bucket) restore(key Key, keyStr, val []byte, ts int64, meta entryMeta) {
+	var err error
+	if b.options.Immutable && meta.flags&flagPinned != 0 {
+		_, err = b.setPinned(key, keyStr, val)
+	} else {
+		_, err = b.set(key, keyStr, val, ts)
+	}
+	if err != nil {
+		return
+	}
+	idx, _ := b.index.Get(key)
+	if b.options.TrackCreation && meta.created != 0 {
+		b.putCreated(idx, meta.created)
+	}
+	if b.options.Versioned {
+		b.putVersion(idx, meta.version)
+	}
+	if b.options.VerifyChecksum {
+		b.putChecksum(idx)
+	}
+}
+
+// setMiss stores a tombstone with no value for the given key.
+func (b *bucket) setMiss(key Key, keyStr []byte, ts int64) (newField bool, err error) {
+	return b.put(key, keyStr, nil, ts, flagMiss)
+}
+
+// setPinned stores the key-value pair without expiration as a pinned entry.
+func (b *bucket) setPinned(key Key, keyStr, val []byte) (newField bool, err error) {
+	val, flags := b.encode(val)
+	return b.put(key, keyStr, val, noTTL, flags|flagPinned)
+}
+
+// put stores the encoded value with flags into the bucket.
+func (b *bucket) put(key Key, keyStr, val []byte, ts int64, flags byte) (newField bool, err error) {
 	idx, found := b.index.Get(key)
+	// A corrupted entry can not be updated in-place, it is dropped and reclaimed by migration.
+	// Its lengths are clamped by findEntry, but may still cover the entries after it, so the
+	// unused bytes and padding are bounded to avoid counting more than data holds.
+	if found && b.corrupted(idx) {
+		entry, _, _ := b.findEntry(idx)
+		b.unused = min(b.unused+uint64(len(entry)), uint64(len(b.data)))
+		b.padded -= min(uint64(b.padding(idx)), b.padded)
+		b.index.Delete(key)
+		found = false
+	}
 	if found {
 		entry, oldKeyStr, oldVal := b.findEntry(idx)
 
-		// Update in-place if the lengths match.
-		if len(keyStr) == len(oldKeyStr) && len(val) == len(oldVal) {
+		// Pinned entries are write-once.
+		if b.options.Immutable && !idx.expired() && b.isPinned(idx) {
+			return false, ErrRejected
+		}
+
+		// Resolve hash conflict with a different alive key.
+		if onConflict := b.options.OnHashConflict; onConflict != nil &&
+			!bytes.Equal(keyStr, oldKeyStr) && !idx.expired() && !onConflict(keyStr, oldKeyStr) {
+			return false, ErrRejected
+		}
+
+		// Update in-place if the lengths match, or the value fits in the padded space.
+		pad := b.padding(idx)
+		if len(keyStr) == len(oldKeyStr) && len(val) <= len(oldVal)+pad &&
+			(b.options.PadValues || len(val) == len(oldVal)) {
 			copy(oldKeyStr, keyStr)
-			copy(oldVal, val)
+			copy(oldVal[:len(val)], val)
+			if b.options.PadValues {
+				newPad := len(oldVal) + pad - len(val)
+				b.putPadding(idx, newPad)
+				b.padded += uint64(newPad) - uint64(pad)
+			}
+			if b.hasFlags() {
+				b.putFlags(idx, flags)
+			}
+			if b.options.TrackCreation && idx.expired() {
+				b.putCreated(idx, time.Now().Unix())
+			}
+			if b.options.TrackRecent {
+				b.putSeq(idx)
+			}
+			if b.options.Versioned {
+				b.putVersion(idx, 0)
+			}
+			if b.options.VerifyChecksum {
+				b.putChecksum(idx)
+			}
 			b.index.Put(key, idx.setTTL(ts))
-			return false
+			return false, nil
 		}
 
 		// Allocate new space if lengths differ.
-		b.unused += uint32(len(entry))
+		b.discard(idx, entry)
+	}
+
+	// Make room for the new key.
+	if !found && b.options.MaxEntriesPerBucket > 0 && b.index.Len() >= b.options.MaxEntriesPerBucket {
+		if b.options.FullPolicy == FullReject {
+			b.sampleExpired(b.evictionSamples())
+			if b.index.Len() >= b.options.MaxEntriesPerBucket {
+				b.rejections++
+				return false, ErrRejected
+			}
+		}
+		for b.index.Len() >= b.options.MaxEntriesPerBucket {
+			b.evictSample()
+		}
 	}
 
 	// Insert new entry.
-	b.index.Put(key, b.appendEntry(keyStr, val, ts))
-	return true
+	added := b.appendEntry(keyStr, val, ts, flags)
+	if found && b.options.TrackCreation && !idx.expired() {
+		b.putCreated(added, b.created(idx))
+		if b.options.VerifyChecksum {
+			b.putChecksum(added)
+		}
+	}
+	b.index.Put(key, added)
+	return true, nil
 }
 
 // appendEntry appends a key-value entry to the data slice and returns the index.
-func (b *bucket) appendEntry(keyStr, val []byte, ts int64) Idx {
+func (b *bucket) appendEntry(keyStr, val []byte, ts int64, flags byte) Idx {
 	idx := newIdx(len(b.data), ts)
-	// Append key length, value length, key, and value.
+	vlen := len(val)
+	if b.options.PadValues {
+		vlen = sizeClass(len(val))
+	}
+	b.grow(SizeUvarint(uint64(len(keyStr))) + SizeUvarint(uint64(vlen)) + b.extraSize() + len(keyStr) + vlen)
+	// Append key length, value length, (flags), (creation time), (sequence), (version), (checksum), (padding), key, and value.
 	b.data = binary.AppendUvarint(b.data, uint64(len(keyStr)))
-	b.data = binary.AppendUvarint(b.data, uint64(len(val)))
+	b.data = binary.AppendUvarint(b.data, uint64(vlen))
+	if b.hasFlags() {
+		b.data = append(b.data, flags)
+	}
+	if b.options.TrackCreation {
+		b.data = binary.LittleEndian.AppendUint32(b.data, uint32(time.Now().Unix()))
+	}
+	if b.options.TrackRecent {
+		b.data = binary.LittleEndian.AppendUint64(b.data, entrySeq.Add(1))
+	}
+	if b.options.Versioned {
+		b.data = binary.LittleEndian.AppendUint64(b.data, 0)
+	}
+	if b.options.VerifyChecksum {
+		b.data = binary.LittleEndian.AppendUint32(b.data, 0)
+	}
+	pad := vlen - len(val)
+	if b.options.PadValues {
+		b.data = binary.LittleEndian.AppendUint32(b.data, uint32(pad))
+		b.padded += uint64(pad)
+	}
 	b.data = append(b.data, keyStr...)
 	b.data = append(b.data, val...)
+	b.data = append(b.data, make([]byte, pad)...)
+	if b.options.VerifyChecksum {
+		b.putChecksum(idx)
+	}
+	b.peakAlloc = max(b.peakAlloc, len(b.data))
 	return idx
 }
 
-// remove deletes the key-value pair from the bucket.
-func (b *bucket) remove(key Key) bool {
+// grow ensures space for another n bytes in data. Once the capacity reaches
+// BufferGrowStep, it grows by a fixed step instead of doubling by append.
+func (b *bucket) grow(n int) {
+	step := b.options.BufferGrowStep
+	if step <= 0 || cap(b.data) < step || len(b.data)+n <= cap(b.data) {
+		return
+	}
+	newData := make([]byte, len(b.data), cap(b.data)+max(step, n))
+	copy(newData, b.data)
+	b.data = newData
+}
+
+// reserve presizes the index for n entries and data for size bytes.
+func (b *bucket) reserve(n, size int) {
+	if n > b.index.Len() {
+		index := newIndex(b.options.IndexKind, n)
+		b.index.All(func(key Key, idx Idx) bool {
+			index.Put(key, idx)
+			return true
+		})
+		b.index = index
+	}
+	if size > cap(b.data) {
+		newData := make([]byte, len(b.data), size)
+		copy(newData, b.data)
+		b.data = newData
+	}
+}
+
+// remove deletes the key-value pair from the bucket, and reports whether it was alive,
+// tombstones are removed but not reported. The stored key is compared with keyStr as peek does.
+func (b *bucket) remove(key Key, keyStr []byte) bool {
 	idx, found := b.index.Get(key)
 	if found {
+		if _, kstr, _ := b.findEntry(idx); !b.keyMatches(kstr, keyStr) {
+			return false
+		}
+		alive := !idx.expired() && !b.isMiss(idx)
 		b.removeEntry(key, idx)
-		return !idx.expired()
+		return alive
 	}
 
 	return false
 }
 
-// setTTL updates the expiration timestamp for a given key.
-func (b *bucket) setTTL(key Key, ts int64) bool {
+// setTTL updates the expiration timestamp for a given key, pinned entries are not updated.
+// The stored key is compared with keyStr as peek does.
+func (b *bucket) setTTL(key Key, keyStr []byte, ts int64) bool {
 	idx, found := b.index.Get(key)
 	if found && !idx.expired() {
+		if _, kstr, _ := b.findEntry(idx); !b.keyMatches(kstr, keyStr) {
+			return false
+		}
+		if b.options.Immutable && b.isPinned(idx) {
+			return false
+		}
 		b.index.Put(key, newIdx(idx.start(), ts))
 		return true
 	}
@@ -131,20 +452,135 @@ func (b *bucket) setTTL(key Key, ts int64) bool {
 }
 
 // scan iterates over all alive key-value pairs, calling the Walker function for each.
-func (b *bucket) scan(walker Walker) (next bool) {
+func (b *bucket) scan(walker Walker) bool {
+	return b.scanHashed(func(_ Key, kstr, val []byte, ttl int64) bool {
+		return walker(kstr, val, ttl)
+	})
+}
+
+// scanHashed is like scan, but also passes the key of index, which is not always the hash
+// of the stored key, see SetByHash.
+func (b *bucket) scanHashed(fn func(key Key, kstr, val []byte, ttl int64) bool) (next bool) {
 	next = true
 
-	b.index.All(func(_ Key, idx Idx) bool {
+	b.index.All(func(key Key, idx Idx) bool {
 		if idx.expired() {
 			return true
 		}
+		if b.isMiss(idx) {
+			return true
+		}
+		if b.corrupted(idx) {
+			return true
+		}
+		_, kstr, val := b.findEntry(idx)
+		if val, ok := b.decode(idx, val); ok {
+			next = fn(key, kstr, val, idx.lo)
+		}
+		return next
+	})
+	return
+}
+
+// keyPos is a key of index with the position of its entry.
+type keyPos struct {
+	key   Key
+	start int
+}
+
+// positions appends the keys of index with their positions to items in order of position.
+func (b *bucket) positions(items []keyPos) []keyPos {
+	b.index.All(func(key Key, idx Idx) bool {
+		items = append(items, keyPos{key, idx.start()})
+		return true
+	})
+	slices.SortFunc(items, func(a, b keyPos) int {
+		return a.start - b.start
+	})
+	return items
+}
+
+// scanChunk visits up to n keys of items from i, and calls fn for the alive ones whose
+// entries have not moved since items were taken by positions. It returns the index to
+// resume from, which is len(items) when done.
+func (b *bucket) scanChunk(items []keyPos, i, n int, fn func(key, val []byte, ttl int64)) int {
+	nanosec := time.Now().UnixNano()
+	for ; n > 0 && i < len(items); i, n = i+1, n-1 {
+		idx, ok := b.index.Get(items[i].key)
+		if !ok || idx.start() != items[i].start || idx.expiredWith(nanosec) || b.isMiss(idx) {
+			continue
+		}
+		if b.corrupted(idx) {
+			continue
+		}
 		_, kstr, val := b.findEntry(idx)
-		next = walker(kstr, val, idx.lo)
+		if val, ok = b.decode(idx, val); ok {
+			fn(kstr, val, idx.lo)
+		}
+	}
+	return i
+}
+
+// scanExpired iterates over expired key-value pairs that are still in the index.
+func (b *bucket) scanExpired(fn func(key []byte, expiredAt int64) bool) (next bool) {
+	next = true
+	nanosec := time.Now().UnixNano()
+
+	b.index.All(func(_ Key, idx Idx) bool {
+		if !idx.expiredWith(nanosec) || b.isMiss(idx) {
+			return true
+		}
+		if b.corrupted(idx) {
+			return true
+		}
+		_, kstr, _ := b.findEntry(idx)
+		next = fn(kstr, idx.lo)
 		return next
 	})
 	return
 }
 
+// removeIf removes all alive key-value pairs for which match and fn return true and returns
+// the count. match is called with the key only, so that values of the other keys are not
+// decoded, and fn is called after it. Either may be nil to match all keys.
+func (b *bucket) removeIf(match func(key []byte) bool, fn func(key, val []byte, ttl int64) bool) (removed int) {
+	nanosec := time.Now().UnixNano()
+	b.index.All(func(key Key, idx Idx) bool {
+		if idx.expiredWith(nanosec) || b.isMiss(idx) {
+			return true
+		}
+		_, kstr, val := b.findEntry(idx)
+		if match != nil && !match(kstr) {
+			return true
+		}
+		if b.corrupted(idx) {
+			return true
+		}
+		if fn != nil {
+			var ok bool
+			if val, ok = b.decode(idx, val); !ok || !fn(kstr, val, idx.lo) {
+				return true
+			}
+		}
+		b.removeEntry(key, idx)
+		removed++
+		return true
+	})
+	return
+}
+
+// liveLen returns the number of unexpired key-value pairs in the bucket, excluding tombstones.
+func (b *bucket) liveLen() (n int) {
+	nanosec := time.Now().UnixNano()
+	b.index.All(func(_ Key, idx Idx) bool {
+		if !idx.expiredWith(nanosec) && !b.isMiss(idx) {
+			n++
+		}
+		return true
+	})
+	return
+}
+
 func (b *bucket) evictExpiredKeys(force ...bool) {
 	flag := len(force) > 0 && force[0]
 	if !flag {
@@ -162,12 +598,16 @@ func (b *bucket) evictExpiredKeys(force ...bool) {
 	var failed int
 	nanosec := time.Now().UnixNano()
 
-	// Probing
+	// Probing, the start position of index iteration is not uniform for swiss index,
+	// so with a stride each pass probes a different random subset of keys instead.
+	filter := b.probeFilter()
 	b.index.All(func(key Key, idx Idx) bool {
+		if filter.skip(key) {
+			return true
+		}
 		b.probes++
 		if idx.expiredWith(nanosec) {
-			b.removeEntry(key, idx)
-			b.evictions++
+			b.evict(key, idx)
 			failed = 0
 		} else {
 			failed++
@@ -175,57 +615,335 @@ func (b *bucket) evictExpiredKeys(force ...bool) {
 		return failed <= maxFailed
 	})
 
-	// Check if migration is needed.
-	unusedRate := float64(b.unused) / float64(len(b.data))
-	if unusedRate >= b.options.MigrateRatio {
+	b.migrateIfNeeded()
+}
+
+// probeFilter chooses the keys probed in a pass of sampling, see EvictProbeStride.
+type probeFilter struct {
+	stride uint64
+	seed   uint64
+}
+
+// probeFilter returns a filter with a random seed for a new pass.
+func (b *bucket) probeFilter() probeFilter {
+	return probeFilter{stride: uint64(max(b.options.EvictProbeStride, 1)), seed: rand.Uint64()}
+}
+
+// skip reports whether key is not probed in the pass, it is always false if stride <= 1.
+func (f probeFilter) skip(key Key) bool {
+	return f.stride > 1 && ((key.Lo^f.seed)*0x9e3779b97f4a7c15)>>32%f.stride != 0
+}
+
+// sampleExpired probes at most n key-value pairs and removes the expired ones.
+// The keys are chosen as in evictExpiredKeys.
+func (b *bucket) sampleExpired(n int) (removed int) {
+	nanosec := time.Now().UnixNano()
+	filter := b.probeFilter()
+	b.index.All(func(key Key, idx Idx) bool {
+		if filter.skip(key) {
+			return true
+		}
+		b.probes++
+		if idx.expiredWith(nanosec) {
+			b.evict(key, idx)
+			removed++
+		}
+		n--
+		return n > 0
+	})
+
+	b.migrateIfNeeded()
+	return
+}
+
+// evictionSamples returns EvictionSamples, or the default if not set.
+func (b *bucket) evictionSamples() int {
+	if b.options.EvictionSamples <= 0 {
+		return defaultEvictionSamples
+	}
+	return b.options.EvictionSamples
+}
+
+// evictSample samples a few key-value pairs, removes the expired ones, or the one
+// expiring soonest if none expired, keys without expiration are evicted last.
+// The keys are chosen as in evictExpiredKeys, so a pass may sample no key.
+func (b *bucket) evictSample() {
+	samples := b.evictionSamples()
+	var victim Key
+	var victimIdx Idx
+	var n, removed int
+	nanosec := time.Now().UnixNano()
+	filter := b.probeFilter()
+
+	b.index.All(func(key Key, idx Idx) bool {
+		if filter.skip(key) {
+			return true
+		}
+		b.probes++
+		if idx.expiredWith(nanosec) {
+			b.evict(key, idx)
+			removed++
+		} else {
+			if n == 0 || expireBefore(idx, victimIdx) {
+				victim, victimIdx = key, idx
+			}
+			n++
+		}
+		return n+removed < samples
+	})
+
+	if removed == 0 && n > 0 {
+		b.evict(victim, victimIdx)
+		b.capEvicts++
+	}
+}
+
+// expireBefore reports whether a expires before b, no expiration is the latest.
+func expireBefore(a, b Idx) bool {
+	if a.lo == noTTL {
+		return false
+	}
+	return b.lo == noTTL || a.lo < b.lo
+}
+
+// deleteExpired removes all expired key-value pairs in the bucket and returns the count.
+func (b *bucket) deleteExpired() (removed int) {
+	nanosec := time.Now().UnixNano()
+	b.index.All(func(key Key, idx Idx) bool {
+		b.probes++
+		if idx.expiredWith(nanosec) {
+			b.evict(key, idx)
+			removed++
+		}
+		return true
+	})
+
+	b.migrateIfNeeded()
+	return
+}
+
+// migrateIfNeeded performs migration when the unused rate reaches MigrateRatio
+// and the unused bytes reach MigrateMinBytes, and reports whether migrated.
+// It is postponed while the bucket is scanned by ScanChunked.
+func (b *bucket) migrateIfNeeded() bool {
+	if b.scanners.Load() == 0 && b.needsMigrate() {
 		b.migrate()
+		return true
 	}
+	return false
+}
+
+// needsMigrate reports whether the unused space reaches MigrateRatio and MigrateMinBytes.
+func (b *bucket) needsMigrate() bool {
+	unusedRate := float64(b.unused) / float64(len(b.data))
+	return unusedRate >= b.options.MigrateRatio && b.unused >= b.options.MigrateMinBytes
 }
 
 // migrate transfers valid key-value pairs to a new container to save memory.
 func (b *bucket) migrate() {
-	newData := make([]byte, 0, len(b.data))
+	if b.options.ReuseMigrateBuffer && cap(b.spare) >= len(b.data)-int(b.unused) {
+		b.migrateInto(b.spare[:0])
+	} else {
+		b.migrateInto(make([]byte, 0, len(b.data)))
+	}
+}
+
+// migrateInto transfers valid key-value pairs to newData, which must be empty.
+func (b *bucket) migrateInto(newData []byte) {
+	before := len(b.data)
+	start := time.Now()
+	var moved int
 
 	// Migrate data to the new bucket.
+	type item struct {
+		key Key
+		idx Idx
+	}
+	var pinned []item
 	nanosec := time.Now().UnixNano()
 	b.index.All(func(key Key, idx Idx) bool {
 		if idx.expiredWith(nanosec) {
+			b.notifyEvicted(idx)
+			b.padded -= uint64(b.padding(idx))
 			b.index.Delete(key)
 			return true
 		}
+		if b.options.Immutable && b.isPinned(idx) {
+			pinned = append(pinned, item{key, idx})
+			return true
+		}
 		// Update with new position.
 		b.index.Put(key, newIdxx(len(newData), idx))
 		entry, _, _ := b.findEntry(idx)
 		newData = append(newData, entry...)
+		moved++
 		return true
 	})
 
+	// Pinned entries are moved together to the end, in their original order.
+	slices.SortFunc(pinned, func(a, b item) int {
+		return a.idx.start() - b.idx.start()
+	})
+	for _, it := range pinned {
+		entry, _, _ := b.findEntry(it.idx)
+		b.index.Put(it.key, newIdxx(len(newData), it.idx))
+		newData = append(newData, entry...)
+		moved++
+	}
+
+	if b.options.ReuseMigrateBuffer {
+		b.spare = b.data
+	}
 	b.data = newData
 	b.unused = 0
 	b.migrations++
+	b.layout++
+	took := time.Since(start)
+
+	if b.options.OnMigrate != nil {
+		b.options.OnMigrate(b.id, before, len(b.data))
+	}
+	if b.options.OnMigrateDone != nil {
+		b.options.OnMigrateDone(b.id, moved, len(b.data), took)
+	}
+}
+
+// compact slides alive entries toward the front of data in-place and truncates it,
+// which avoids allocating a new container as migrate does. It collects the keys of
+// the index with their positions, which costs 32 bytes per key of scratch space.
+// If VerifyChecksum is enabled, it migrates instead, since a corrupted length would
+// overwrite the entries after it.
+func (b *bucket) compact() {
+	if b.options.VerifyChecksum {
+		b.migrate()
+		return
+	}
+	before := len(b.data)
+	start := time.Now()
+	type item struct {
+		key Key
+		idx Idx
+	}
+	items := make([]item, 0, b.index.Len())
+
+	nanosec := time.Now().UnixNano()
+	b.index.All(func(key Key, idx Idx) bool {
+		if idx.expiredWith(nanosec) {
+			b.notifyEvicted(idx)
+			b.padded -= uint64(b.padding(idx))
+			b.index.Delete(key)
+			return true
+		}
+		items = append(items, item{key, idx})
+		return true
+	})
+	// Entries must move in order of position, so that they never overwrite each other.
+	slices.SortFunc(items, func(a, b item) int {
+		return a.idx.start() - b.idx.start()
+	})
+
+	// Entries already in place are not copied, nor counted as moved.
+	var pos, moved, bytesMoved int
+	for _, it := range items {
+		entry, _, _ := b.findEntry(it.idx)
+		if it.idx.start() != pos {
+			copy(b.data[pos:], entry)
+			b.index.Put(it.key, newIdxx(pos, it.idx))
+			moved++
+			bytesMoved += len(entry)
+		}
+		pos += len(entry)
+	}
+
+	b.data = b.data[:pos]
+	b.unused = 0
+	b.migrations++
+	b.layout++
+	took := time.Since(start)
+
+	if b.options.OnMigrate != nil {
+		b.options.OnMigrate(b.id, before, len(b.data))
+	}
+	if b.options.OnMigrateDone != nil {
+		b.options.OnMigrateDone(b.id, moved, bytesMoved, took)
+	}
+}
+
+// shrink migrates the bucket into a container sized to its used bytes if it has unused bytes,
+// and reallocates data to fit its length if the capacity still exceeds shrinkFactor times the
+// length, e.g. when expired keys are dropped by the migration.
+func (b *bucket) shrink() {
+	if b.unused > 0 {
+		b.migrateInto(make([]byte, 0, len(b.data)-int(b.unused)))
+	}
+	if cap(b.data) > shrinkFactor*len(b.data) {
+		data := make([]byte, len(b.data))
+		copy(data, b.data)
+		b.data = data
+	}
+	b.spare = nil
 }
 
 // findEntry retrieves the full entry, key, and value bytes for the given index.
 func (b *bucket) findEntry(idx Idx) (entry, kstr, val []byte) {
-	pos := idx.start()
-	// read keyLen
-	klen, n := binary.Uvarint(b.data[pos:])
-	pos += n
-	// read valLen
-	vlen, n := binary.Uvarint(b.data[pos:])
-	pos += n
+	// read keyLen and valLen
+	klen, vlen, pos := b.lengths(idx.start())
+	// skip optional fields in header
+	pos += b.extraSize()
+	var pad uint64
+	if b.options.PadValues {
+		pad = uint64(binary.LittleEndian.Uint32(b.data[pos-paddingSize:]))
+	}
+	// keep corrupted lengths inside data, they fail the checksum.
+	if b.options.VerifyChecksum {
+		klen = min(klen, uint64(len(b.data)-pos))
+		vlen = min(vlen, uint64(len(b.data)-pos)-klen)
+		pad = min(pad, vlen)
+	}
 	// read kstr
 	kstr = b.data[pos : pos+int(klen)]
 	pos += int(klen)
 	// read value
-	val = b.data[pos : pos+int(vlen)]
+	val = b.data[pos : pos+int(vlen-pad)]
 	pos += int(vlen)
 
 	return b.data[idx.start():pos], kstr, val
 }
 
+// evict removes the entry and sends a copy of it to EvictChannel without blocking.
+func (b *bucket) evict(key Key, idx Idx) {
+	b.notifyEvicted(idx)
+	b.removeEntry(key, idx)
+}
+
+// notifyEvicted sends a copy of the entry to EvictChannel without blocking and counts
+// the eviction, for paths that drop the entry without marking it unused.
+// Tombstones and corrupted entries are counted but not sent.
+func (b *bucket) notifyEvicted(idx Idx) {
+	if ch := b.options.EvictChannel; ch != nil {
+		_, kstr, val := b.findEntry(idx)
+		if b.isMiss(idx) || b.corrupted(idx) {
+			b.evictions++
+			return
+		}
+		val, _ = b.decode(idx, val)
+		select {
+		case ch <- EvictedEntry{Key: string(kstr), Value: slices.Clone(val), TTL: idx.lo}:
+		default:
+			b.dropped++
+		}
+	}
+	b.evictions++
+}
+
 func (b *bucket) removeEntry(key Key, idx Idx) {
 	entry, _, _ := b.findEntry(idx)
-	b.unused += uint32(len(entry))
+	b.discard(idx, entry)
 	b.index.Delete(key)
 }
+
+// discard marks the entry as unused, its padding is counted in unused from now on.
+func (b *bucket) discard(idx Idx, entry []byte) {
+	b.unused += uint64(len(entry))
+	b.padded -= uint64(b.padding(idx))
+}
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -9,7 +10,7 @@ import (
 )
 
 func testSetAndGet(assert *assert.Assertions, options Options) {
-	b := newBucket(options)
+	b := newBucket(0, options)
 	for i := 0; i < 100; i++ {
 		kstr := fmt.Sprintf("%08d", i)
 		key := xxh3.HashString128(kstr)
@@ -19,7 +20,7 @@ func testSetAndGet(assert *assert.Assertions, options Options) {
 	for i := 0; i < 100; i++ {
 		kstr := fmt.Sprintf("%08d", i)
 		key := xxh3.HashString128(kstr)
-		val, _, ok := b.get(key)
+		val, _, ok := b.get(key, []byte(kstr))
 		assert.Equal(kstr, string(val))
 		assert.True(ok)
 	}
@@ -31,9 +32,105 @@ func TestBucket(t *testing.T) {
 	options := DefaultOptions
 	testSetAndGet(assert, options)
 
+	options.LockKind = LockMutex
+	testSetAndGet(assert, options)
+
 	options.ConcurrencySafe = false
+	options.LockKind = LockNone
 	testSetAndGet(assert, options)
 
 	options.ShardCount = 1
 	testSetAndGet(assert, options)
+
+	options.IndexKind = IndexMap
+	testSetAndGet(assert, options)
+}
+
+func TestBucketGrowStep(t *testing.T) {
+	assert := assert.New(t)
+
+	options := DefaultOptions
+	options.BufferSize = 16
+	options.BufferGrowStep = 64
+	b := newBucket(0, options)
+
+	for i := 0; i < 100; i++ {
+		kstr := fmt.Sprintf("%08d", i)
+		b.set(xxh3.HashString128(kstr), []byte(kstr), []byte(kstr), 0)
+		if cap(b.data) > 64 {
+			// grow with fixed step.
+			assert.Equal(cap(b.data)%64, 0)
+		}
+	}
+	testSetAndGet(assert, options)
+}
+
+func TestBucketHashConflict(t *testing.T) {
+	assert := assert.New(t)
+	key := xxh3.HashString128("conflict")
+
+	// reject.
+	var conflicts int
+	options := DefaultOptions
+	options.OnHashConflict = func(key, conflictKey []byte) bool {
+		assert.Equal(string(key), "k2")
+		assert.Equal(string(conflictKey), "k1")
+		conflicts++
+		return false
+	}
+	b := newBucket(0, options)
+	newField, err := b.set(key, []byte("k1"), []byte("v1"), 0)
+	assert.True(newField)
+	assert.Nil(err)
+	newField, err = b.set(key, []byte("k2"), []byte("v2"), 0)
+	assert.False(newField)
+	assert.Equal(err, ErrRejected)
+	assert.Equal(conflicts, 1)
+
+	val, _, ok := b.get(key, []byte("k1"))
+	assert.True(ok)
+	assert.Equal(string(val), "v1")
+
+	// same key is not a conflict, and updated in place.
+	newField, err = b.set(key, []byte("k1"), []byte("v3"), 0)
+	assert.False(newField)
+	assert.Nil(err)
+	assert.Equal(conflicts, 1)
+
+	// overwrite.
+	options.OnHashConflict = func(key, conflictKey []byte) bool {
+		return true
+	}
+	b = newBucket(0, options)
+	b.set(key, []byte("k1"), []byte("v1"), 0)
+	b.set(key, []byte("k2"), []byte("v2"), 0)
+
+	val, _, ok = b.get(key, []byte("k2"))
+	assert.True(ok)
+	assert.Equal(string(val), "v2")
+}
+
+func TestBucketLockKind(t *testing.T) {
+	assert := assert.New(t)
+
+	options := DefaultOptions
+	assert.IsType(&sync.RWMutex{}, newBucket(0, options).rwlocker)
+
+	options.LockKind = LockMutex
+	assert.IsType(&mutexLocker{}, newBucket(0, options).rwlocker)
+
+	options.TrackLockWait = true
+	assert.IsType(&timedLocker{}, newBucket(0, options).rwlocker)
+
+	options.ConcurrencySafe = false
+	options.LockKind = LockNone
+	assert.IsType(&emptyLocker{}, newBucket(0, options).rwlocker)
+
+	options.LockKind = LockDefault
+	assert.IsType(&emptyLocker{}, newBucket(0, options).rwlocker)
+
+	// explicit LockNone overrides ConcurrencySafe.
+	options.ConcurrencySafe = true
+	options.LockKind = LockNone
+	assert.IsType(&emptyLocker{}, newBucket(0, options).rwlocker)
 }
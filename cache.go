@@ -1,8 +1,18 @@
 package cache
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
 	"math/rand/v2"
 	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,12 +20,46 @@ const (
 	noTTL     = 0
 	KB        = 1024
 	maxFailed = 3 // maxFailed indicates that the eviction algorithm breaks when consecutive unexpired key-value pairs are detected.
+
+	defaultEvictionSamples = 5
+
+	scanCheckInterval = 1024 // scanCheckInterval is the number of pairs scanned between checks of context.
+
+	shrinkFactor = 2 // shrinkFactor is the ratio of capacity to length of data for Shrink to reallocate.
+
+	defaultWarmUpEntrySize = 64 // defaultWarmUpEntrySize is the entry size WarmUp assumes for an empty cache.
 )
 
+// ErrRejected is returned when a key is rejected by OnHashConflict or FullReject,
+// or would overwrite a key stored by SetImmutable.
+var ErrRejected = errors.New("cache: key rejected")
+
 // GigaCache implements a key-value cache.
 type GigaCache struct {
 	mask    uint32
+	options Options
 	buckets []*bucket
+
+	// reshardMu is held exclusively by Reshard while it replaces buckets, and shared by
+	// methods accessing buckets, mask and options through rlock if Reshardable is enabled.
+	// getShard must be called under it.
+	reshardMu sync.RWMutex
+
+	// evictCursor is the next bucket to sweep by EvictExpiredKeys and EvictWithBudget.
+	evictCursor atomic.Uint32
+
+	// latencies of Set and Get if TrackLatency is enabled.
+	setLatency *shardedPercentile
+	getLatency *shardedPercentile
+
+	// calls are the in-flight computes of GetOrCompute.
+	callsMu sync.Mutex
+	calls   map[Key]*call
+
+	// background goroutines.
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
 }
 
 // New creates a new instance of GigaCache.
@@ -23,43 +67,491 @@ func New(options Options) *GigaCache {
 	if err := validateOptions(options); err != nil {
 		panic(err)
 	}
+	if options.ShardCount == 0 {
+		options.ShardCount = autoShardCount()
+	}
 	cache := &GigaCache{
 		mask:    options.ShardCount - 1,
+		options: options,
 		buckets: make([]*bucket, options.ShardCount),
+		done:    make(chan struct{}),
 	}
 	for i := range cache.buckets {
-		cache.buckets[i] = newBucket(options)
+		cache.buckets[i] = newBucket(i, options)
+	}
+	if path := options.PersistPath; path != "" {
+		if err := cache.load(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("cache: failed to load %s, starting empty: %v", path, err)
+			for i := range cache.buckets {
+				cache.buckets[i] = newBucket(i, options)
+			}
+		}
+	}
+	cache.evictCursor.Store(rand.Uint32())
+	if options.TrackLatency {
+		cache.setLatency = newShardedPercentile(int(options.ShardCount))
+		cache.getLatency = newShardedPercentile(int(options.ShardCount))
+	}
+
+	if options.ActiveExpiryInterval > 0 {
+		cache.wg.Add(1)
+		go cache.activeExpiry(options.ActiveExpiryInterval)
 	}
 	return cache
 }
 
+// Close saves the cache to PersistPath if set, and stops the background goroutines of the cache.
+// It is safe to call Close more than once, only the first call saves.
+func (c *GigaCache) Close() (err error) {
+	c.closeOnce.Do(func() {
+		if path := c.options.PersistPath; path != "" {
+			err = c.save(path)
+		}
+		close(c.done)
+		c.wg.Wait()
+	})
+	return
+}
+
+// rlock takes reshardMu shared if Reshardable is enabled, otherwise buckets never change.
+func (c *GigaCache) rlock() {
+	if c.options.Reshardable {
+		c.reshardMu.RLock()
+	}
+}
+
+// runlock releases the lock taken by rlock.
+func (c *GigaCache) runlock() {
+	if c.options.Reshardable {
+		c.reshardMu.RUnlock()
+	}
+}
+
 func (c *GigaCache) getShard(keyStr string) (*bucket, Key) {
 	hash := hashFn(keyStr)
+	return c.buckets[c.shardIndex(hash)], hash
+}
+
+func (c *GigaCache) shardIndex(hash Key) uint32 {
+	return shardOf(hash, c.mask)
+}
+
+func shardOf(hash Key, mask uint32) uint32 {
 	// shard with different hash function.
 	hash32 := uint32(hash.Lo >> 1)
-	return c.buckets[hash32&c.mask], hash
+	return hash32 & mask
+}
+
+// lockBuckets locks the buckets at indices in ascending order to avoid deadlock
+// between multi-key operations, duplicate indices are locked once.
+// It returns the function to unlock them.
+func (c *GigaCache) lockBuckets(indices ...int) (unlock func()) {
+	indices = slices.Clone(indices)
+	slices.Sort(indices)
+	indices = slices.Compact(indices)
+	for _, i := range indices {
+		c.buckets[i].Lock()
+	}
+	return func() {
+		for i := len(indices) - 1; i >= 0; i-- {
+			c.buckets[indices[i]].Unlock()
+		}
+	}
+}
+
+// ShardIndex returns the bucket index the key lands in, e.g. to batch operations by shard.
+func (c *GigaCache) ShardIndex(keyStr string) int {
+	c.rlock()
+	defer c.runlock()
+	return int(c.shardIndex(hashFn(keyStr)))
+}
+
+// ShardForHash returns the bucket index of a precomputed 128-bit hash of key, see SetByHash.
+func (c *GigaCache) ShardForHash(hi, lo uint64) int {
+	c.rlock()
+	defer c.runlock()
+	return int(c.shardIndex(Key{Hi: hi, Lo: lo}))
+}
+
+// GetByHash is like Get, but accepts a precomputed 128-bit hash of key, see SetByHash.
+// The stored key is not verified even if VerifyKeys is enabled.
+func (c *GigaCache) GetByHash(hi, lo uint64) ([]byte, int64, bool) {
+	c.rlock()
+	defer c.runlock()
+	key := Key{Hi: hi, Lo: lo}
+	bucket := c.buckets[c.shardIndex(key)]
+	bucket.RLock()
+	value, timestamp, found := bucket.get(key, nil)
+	if found && !c.options.NoValueCopy {
+		value = slices.Clone(value)
+	}
+	bucket.RUnlock()
+	return value, timestamp, found
+}
+
+// SetByHash is like SetEx, but accepts a precomputed 128-bit hash of keyStr to skip hashing.
+// A non-positive duration removes the key as SetEx does, use SetByHashTx to store it without TTL.
+// The hash may be any value unique per key, e.g. a unique 64-bit id as lo, whose low bits
+// choose the shard. Scans, Compact, Migrate, Reshard, DumpShardHashed and PersistPath keep
+// the hash. But methods taking a key string hash it with xxh3, so unless the hash is the
+// xxh3 hash of keyStr, the key is not found by Get, Remove and other methods by key, and
+// DumpShard with LoadShard, or ExportJSONL with ImportJSONL, restore it under the xxh3 hash.
+func (c *GigaCache) SetByHash(hi, lo uint64, keyStr string, value []byte, duration time.Duration) bool {
+	if duration <= 0 {
+		c.rlock()
+		defer c.runlock()
+		key := Key{Hi: hi, Lo: lo}
+		bucket := c.buckets[c.shardIndex(key)]
+		bucket.Lock()
+		bucket.remove(key, s2b(&keyStr))
+		bucket.Unlock()
+		return false
+	}
+	return c.SetByHashTx(hi, lo, keyStr, value, time.Now().Add(duration).UnixNano())
+}
+
+// SetByHashTx is like SetTx, but accepts a precomputed 128-bit hash of keyStr as SetByHash.
+func (c *GigaCache) SetByHashTx(hi, lo uint64, keyStr string, value []byte, expiration int64) bool {
+	c.rlock()
+	defer c.runlock()
+	key := Key{Hi: hi, Lo: lo}
+	bucket := c.buckets[c.shardIndex(key)]
+	expiration = c.jitter(expiration)
+	bucket.Lock()
+	bucket.evictExpiredKeys()
+	newField, _ := bucket.set(key, s2b(&keyStr), value, expiration)
+	bucket.Unlock()
+	return newField
+}
+
+// ShardCount returns the number of shards actually used by the cache.
+func (c *GigaCache) ShardCount() int {
+	c.rlock()
+	defer c.runlock()
+	return len(c.buckets)
 }
 
 // Get retrieves the value and its expiration time for a given key.
+// The value is a copy unless NoValueCopy is enabled.
 func (c *GigaCache) Get(keyStr string) ([]byte, int64, bool) {
+	c.rlock()
+	defer c.runlock()
+	var start time.Time
+	if c.getLatency != nil {
+		start = time.Now()
+	}
 	bucket, key := c.getShard(keyStr)
 	bucket.RLock()
-	value, timestamp, found := bucket.get(key)
-	if found {
+	value, timestamp, found := bucket.get(key, s2b(&keyStr))
+	if found && !c.options.NoValueCopy {
 		value = slices.Clone(value)
 	}
+	expired := !found && c.options.LazyExpiryOnGet && bucket.hasExpired(key)
 	bucket.RUnlock()
+
+	if expired {
+		bucket.Lock()
+		if idx, ok := bucket.index.Get(key); ok && idx.expired() {
+			bucket.evict(key, idx)
+		}
+		bucket.Unlock()
+	}
+	if c.getLatency != nil {
+		c.getLatency.add(bucket.id, float64(time.Since(start)))
+	}
 	return value, timestamp, found
 }
 
-// SetTx stores a key-value pair with a specific expiration timestamp.
+// GetOrDefault returns the value for a given key, or def if the key is missing or expired.
+// The value is a copy as returned by Get.
+func (c *GigaCache) GetOrDefault(keyStr string, def []byte) []byte {
+	if value, _, ok := c.Get(keyStr); ok {
+		return value
+	}
+	return def
+}
+
+// GetEx is like Get, but reports whether the key has a TTL separately from its expiration time.
+// expireAt is the zero time if hasTTL is false.
+func (c *GigaCache) GetEx(keyStr string) (value []byte, expireAt time.Time, hasTTL bool, ok bool) {
+	value, timestamp, ok := c.Get(keyStr)
+	if ok && timestamp != noTTL {
+		expireAt, hasTTL = time.Unix(0, timestamp), true
+	}
+	return
+}
+
+// KeyState is the state of a key returned by GetStale.
+type KeyState int
+
+const (
+	Absent KeyState = iota // the key does not exist or has been evicted.
+	Fresh                  // the key exists and is not expired.
+	Stale                  // the key is expired but not yet evicted.
+)
+
+// GetStale is like Get, but also returns the value of an expired key that is not yet evicted,
+// e.g. to serve it while refreshing. It never evicts the key.
+func (c *GigaCache) GetStale(keyStr string) (value []byte, expireAt int64, state KeyState) {
+	c.rlock()
+	defer c.runlock()
+	bucket, key := c.getShard(keyStr)
+	bucket.RLock()
+	defer bucket.RUnlock()
+
+	idx, value, found := bucket.peek(key, s2b(&keyStr))
+	if !found || bucket.isMiss(idx) {
+		return nil, 0, Absent
+	}
+	state = Fresh
+	if idx.expired() {
+		state = Stale
+	}
+	return slices.Clone(value), idx.lo, state
+}
+
+// GetLease is like Get, but copies the value into a buffer leased from BufferPool.
+// The caller must call release exactly once when done, and must not use the value after it.
+func (c *GigaCache) GetLease(keyStr string) (value []byte, release func(), ok bool) {
+	c.rlock()
+	defer c.runlock()
+	bucket, key := c.getShard(keyStr)
+	bucket.RLock()
+	defer bucket.RUnlock()
+
+	value, _, ok = bucket.get(key, s2b(&keyStr))
+	if !ok {
+		return nil, func() {}, false
+	}
+	pool := c.options.BufferPool
+	if pool == nil {
+		return slices.Clone(value), func() {}, true
+	}
+	// Get returns nil if the pool is empty and has no New function.
+	buf, _ := pool.Get().(*[]byte)
+	if buf == nil {
+		buf = new([]byte)
+	}
+	*buf = append((*buf)[:0], value...)
+	return *buf, func() { pool.Put(buf) }, true
+}
+
+// GetOrMiss is like Get, but also finds tombstones stored by SetMiss, for which
+// found and isMiss are both true and the value is nil.
+func (c *GigaCache) GetOrMiss(keyStr string) (value []byte, ttl int64, found, isMiss bool) {
+	c.rlock()
+	defer c.runlock()
+	bucket, key := c.getShard(keyStr)
+	bucket.RLock()
+	defer bucket.RUnlock()
+
+	idx, value, found := bucket.lookup(key, s2b(&keyStr))
+	if !found {
+		return nil, 0, false, false
+	}
+	if bucket.isMiss(idx) {
+		return nil, idx.lo, true, true
+	}
+	if !c.options.NoValueCopy {
+		value = slices.Clone(value)
+	}
+	return value, idx.lo, true, false
+}
+
+// GetImmutable returns the value of a key stored by SetImmutable, ok is false if the key
+// is not found or not pinned.
+func (c *GigaCache) GetImmutable(keyStr string) (value []byte, ok bool) {
+	c.rlock()
+	defer c.runlock()
+	bucket, key := c.getShard(keyStr)
+	bucket.RLock()
+	defer bucket.RUnlock()
+
+	idx, value, found := bucket.find(key, s2b(&keyStr))
+	if !found || !bucket.isPinned(idx) {
+		return nil, false
+	}
+	if !c.options.NoValueCopy {
+		value = slices.Clone(value)
+	}
+	return value, true
+}
+
+// GetUnsafe calls fn with the value and its expiration time for a given key without copying,
+// and reports whether the key was found. The value aliases the internal data of the bucket
+// and writes on the bucket are blocked during the callback.
+// DO NOT MODIFY or RETAIN the value after fn returns.
+func (c *GigaCache) GetUnsafe(keyStr string, fn func(value []byte, ttl int64)) bool {
+	c.rlock()
+	defer c.runlock()
+	bucket, key := c.getShard(keyStr)
+	bucket.RLock()
+	value, timestamp, found := bucket.get(key, s2b(&keyStr))
+	if found {
+		fn(value, timestamp)
+	}
+	bucket.RUnlock()
+	return found
+}
+
+// GetMulti calls fn for each key with its value and expiration time, keys are grouped by bucket
+// so that each bucket is locked only once. The order of calls is not the order of keys.
+// The value aliases the internal data of the bucket and is only valid during the call.
+// DO NOT MODIFY or RETAIN the value after fn returns.
+func (c *GigaCache) GetMulti(keys []string, fn func(key string, value []byte, ttl int64, ok bool)) {
+	c.rlock()
+	defer c.runlock()
+	groups, hashes := c.groupByShard(keys)
+	for bucket, group := range groups {
+		bucket.RLock()
+		for _, i := range group {
+			keyStr := keys[i]
+			value, timestamp, found := bucket.get(hashes[i], s2b(&keyStr))
+			fn(keyStr, value, timestamp, found)
+		}
+		bucket.RUnlock()
+	}
+}
+
+// GetBatchMap returns the copied values of alive keys, missing or expired keys are omitted.
+func (c *GigaCache) GetBatchMap(keys []string) map[string][]byte {
+	result := make(map[string][]byte, len(keys))
+	c.GetMulti(keys, func(key string, value []byte, _ int64, ok bool) {
+		if ok {
+			result[key] = slices.Clone(value)
+		}
+	})
+	return result
+}
+
+// GetSize returns the total bytes the entry of a given key occupies in data, without copying.
+func (c *GigaCache) GetSize(keyStr string) (int, bool) {
+	c.rlock()
+	defer c.runlock()
+	bucket, key := c.getShard(keyStr)
+	bucket.RLock()
+	defer bucket.RUnlock()
+
+	idx, _, found := bucket.find(key, s2b(&keyStr))
+	if !found {
+		return 0, false
+	}
+	entry, _, _ := bucket.findEntry(idx)
+	return len(entry), true
+}
+
+// GetWithAge retrieves the value and the duration since the key was created.
+// The age is always 0 if TrackCreation is disabled.
+func (c *GigaCache) GetWithAge(keyStr string) ([]byte, time.Duration, bool) {
+	c.rlock()
+	defer c.runlock()
+	bucket, key := c.getShard(keyStr)
+	bucket.RLock()
+	defer bucket.RUnlock()
+
+	idx, value, found := bucket.find(key, s2b(&keyStr))
+	if !found {
+		return nil, 0, false
+	}
+
+	var age time.Duration
+	if bucket.options.TrackCreation {
+		age = time.Since(time.Unix(bucket.created(idx), 0))
+	}
+	return slices.Clone(value), age, true
+}
+
+// SetTx stores a key-value pair with a specific expiration timestamp, and reports whether
+// the key is new. It also returns false if the key is rejected, see TrySetTx.
 func (c *GigaCache) SetTx(keyStr string, value []byte, expiration int64) bool {
+	newField, _ := c.TrySetTx(keyStr, value, expiration)
+	return newField
+}
+
+// TrySetTx is like SetTx, but returns ErrRejected if the key is rejected by OnHashConflict,
+// FullReject or SetImmutable, so that an update in place can be told apart from a rejection.
+func (c *GigaCache) TrySetTx(keyStr string, value []byte, expiration int64) (newField bool, err error) {
+	c.rlock()
+	defer c.runlock()
+	var start time.Time
+	if c.setLatency != nil {
+		start = time.Now()
+	}
 	bucket, key := c.getShard(keyStr)
+	expiration = c.jitter(expiration)
 	bucket.Lock()
 	bucket.evictExpiredKeys()
-	newField := bucket.set(key, s2b(&keyStr), value, expiration)
+	newField, err = bucket.set(key, s2b(&keyStr), value, expiration)
 	bucket.Unlock()
-	return newField
+	if c.setLatency != nil {
+		c.setLatency.add(bucket.id, float64(time.Since(start)))
+	}
+	return
+}
+
+// Latencies returns the latencies in nanoseconds of recent SetTx (including Set and SetEx)
+// and Get calls, or nil if TrackLatency is disabled. The results are copies of a window of
+// the most recent calls on all shards, see NewPercentile.
+func (c *GigaCache) Latencies() (set, get *Percentile) {
+	if c.setLatency == nil {
+		return nil, nil
+	}
+	return c.setLatency.merge(), c.getLatency.merge()
+}
+
+// SetAt stores a key-value pair expiring at deadline, a zero deadline means no expiration.
+// A deadline not in the future removes the existing key instead, and returns false as SetEx.
+func (c *GigaCache) SetAt(keyStr string, value []byte, deadline time.Time) bool {
+	if deadline.IsZero() {
+		return c.SetTx(keyStr, value, noTTL)
+	}
+	if !deadline.After(time.Now()) {
+		c.Remove(keyStr)
+		return false
+	}
+	return c.SetTx(keyStr, value, deadline.UnixNano())
+}
+
+// jitter adds a random offset of TTLJitter to the expiration timestamp.
+func (c *GigaCache) jitter(expiration int64) int64 {
+	if jitter := c.options.TTLJitter; jitter > 0 && expiration != noTTL {
+		expiration += int64(FastRand64() % uint64(jitter))
+	}
+	return expiration
+}
+
+// groupByShard groups the positions of keys by the bucket they belong to,
+// and returns the hashed keys in the order of keys, so that they are hashed only once.
+func (c *GigaCache) groupByShard(keys []string) (map[*bucket][]int, []Key) {
+	groups := make(map[*bucket][]int)
+	hashes := make([]Key, len(keys))
+	for i, keyStr := range keys {
+		bucket, key := c.getShard(keyStr)
+		groups[bucket] = append(groups[bucket], i)
+		hashes[i] = key
+	}
+	return groups, hashes
+}
+
+// SetManyTx stores key-value pairs with their own expiration timestamps.
+// Keys are grouped by bucket so that each bucket is locked only once.
+func (c *GigaCache) SetManyTx(keys []string, values [][]byte, expirations []int64) error {
+	c.rlock()
+	defer c.runlock()
+	if len(keys) != len(values) || len(keys) != len(expirations) {
+		return errors.New("cache: keys, values and expirations must have the same length")
+	}
+	groups, hashes := c.groupByShard(keys)
+	for bucket, group := range groups {
+		bucket.Lock()
+		bucket.evictExpiredKeys()
+		for _, i := range group {
+			keyStr := keys[i]
+			bucket.set(hashes[i], s2b(&keyStr), values[i], c.jitter(expirations[i]))
+		}
+		bucket.Unlock()
+	}
+	return nil
 }
 
 // Set stores a key-value pair with no expiration.
@@ -68,36 +560,274 @@ func (c *GigaCache) Set(keyStr string, value []byte) bool {
 }
 
 // SetEx stores a key-value pair with a specific expiration duration.
+// A zero or negative duration removes the existing key instead, and returns false.
 func (c *GigaCache) SetEx(keyStr string, value []byte, duration time.Duration) bool {
+	if duration <= 0 {
+		c.Remove(keyStr)
+		return false
+	}
 	return c.SetTx(keyStr, value, time.Now().Add(duration).UnixNano())
 }
 
+// SetExReturnOld is like SetEx, but returns the expiration timestamp the key had before,
+// existed is false if the key was not alive.
+func (c *GigaCache) SetExReturnOld(keyStr string, value []byte, duration time.Duration) (oldTTL int64, existed bool) {
+	c.rlock()
+	defer c.runlock()
+	bucket, key := c.getShard(keyStr)
+	bucket.Lock()
+	defer bucket.Unlock()
+	bucket.evictExpiredKeys()
+
+	idx, _, existed := bucket.find(key, s2b(&keyStr))
+	if duration <= 0 {
+		bucket.remove(key, s2b(&keyStr))
+	} else {
+		expiration := c.jitter(time.Now().Add(duration).UnixNano())
+		bucket.set(key, s2b(&keyStr), value, expiration)
+	}
+	return idx.lo, existed
+}
+
+// SetMiss stores a tombstone for a key known to be absent with a specific expiration duration,
+// so that repeated lookups can be answered by GetOrMiss. Tombstones are not found by Get or Scan.
+// A zero or negative duration removes the existing key instead, as SetEx does.
+// It panics if NegativeCache is disabled.
+func (c *GigaCache) SetMiss(keyStr string, duration time.Duration) {
+	c.rlock()
+	defer c.runlock()
+	if !c.options.NegativeCache {
+		panic("cache: SetMiss requires NegativeCache option")
+	}
+	bucket, key := c.getShard(keyStr)
+	bucket.Lock()
+	bucket.evictExpiredKeys()
+	if duration <= 0 {
+		bucket.remove(key, s2b(&keyStr))
+	} else {
+		bucket.setMiss(key, s2b(&keyStr), c.jitter(time.Now().Add(duration).UnixNano()))
+	}
+	bucket.Unlock()
+}
+
+// SetImmutable stores a write-once key-value pair without expiration as a pinned entry,
+// which migration keeps together with other pinned entries in their original order.
+// It reports whether it is stored. Once pinned, writes and SetTTL on the key are rejected
+// until it is removed. It panics if Immutable is disabled.
+func (c *GigaCache) SetImmutable(keyStr string, value []byte) bool {
+	c.rlock()
+	defer c.runlock()
+	if !c.options.Immutable {
+		panic("cache: SetImmutable requires Immutable option")
+	}
+	bucket, key := c.getShard(keyStr)
+	bucket.Lock()
+	bucket.evictExpiredKeys()
+	_, err := bucket.setPinned(key, s2b(&keyStr), value)
+	bucket.Unlock()
+	return err == nil
+}
+
+// SetVersioned stores a key-value pair with a version and an expiration duration, no expiration
+// if duration is not positive. It only overwrites an alive key with a lower version, so that
+// out-of-order updates are rejected, and reports whether it is stored. Set resets the version to 0.
+// It panics if Versioned is disabled.
+func (c *GigaCache) SetVersioned(keyStr string, value []byte, version uint64, duration time.Duration) bool {
+	c.rlock()
+	defer c.runlock()
+	if !c.options.Versioned {
+		panic("cache: SetVersioned requires Versioned option")
+	}
+	expiration := int64(noTTL)
+	if duration > 0 {
+		expiration = c.jitter(time.Now().Add(duration).UnixNano())
+	}
+	bucket, key := c.getShard(keyStr)
+	bucket.Lock()
+	bucket.evictExpiredKeys()
+	stored := bucket.setVersioned(key, s2b(&keyStr), value, expiration, version)
+	bucket.Unlock()
+	return stored
+}
+
+// GetVersioned retrieves the value and its version for a given key.
+// The version is always 0 if Versioned is disabled.
+func (c *GigaCache) GetVersioned(keyStr string) ([]byte, uint64, bool) {
+	c.rlock()
+	defer c.runlock()
+	bucket, key := c.getShard(keyStr)
+	bucket.RLock()
+	defer bucket.RUnlock()
+
+	idx, value, found := bucket.find(key, s2b(&keyStr))
+	if !found {
+		return nil, 0, false
+	}
+	var version uint64
+	if c.options.Versioned {
+		version = bucket.version(idx)
+	}
+	return slices.Clone(value), version, true
+}
+
+// WarmUp presizes all buckets for the expected number of entries before a bulk import.
+// Data buffers are presized by the average entry size of current data, or by
+// defaultWarmUpEntrySize if the cache is empty.
+func (c *GigaCache) WarmUp(expectedEntries int) {
+	c.rlock()
+	defer c.runlock()
+	stats := c.stats()
+	avgSize := defaultWarmUpEntrySize
+	if stats.Len > 0 {
+		avgSize = int(stats.Alloc-stats.Unused) / stats.Len
+	}
+	n := expectedEntries / len(c.buckets)
+	for _, bucket := range c.buckets {
+		bucket.Lock()
+		bucket.reserve(n, n*avgSize)
+		bucket.Unlock()
+	}
+}
+
+// SetIfGreater stores the decimal int64 value with a specific expiration duration, only if the key
+// is missing or expired, or the existing value is an int64 strictly less than value.
+// It returns whether the value is stored. A zero or negative duration removes the existing key
+// instead, and returns false, as SetEx does.
+func (c *GigaCache) SetIfGreater(keyStr string, value int64, duration time.Duration) bool {
+	c.rlock()
+	defer c.runlock()
+	bucket, key := c.getShard(keyStr)
+	bucket.Lock()
+	defer bucket.Unlock()
+
+	if duration <= 0 {
+		bucket.evictExpiredKeys()
+		bucket.remove(key, s2b(&keyStr))
+		return false
+	}
+
+	if old, _, found := bucket.get(key, s2b(&keyStr)); found {
+		n, err := strconv.ParseInt(string(old), 10, 64)
+		if err != nil || n >= value {
+			return false
+		}
+	}
+	bucket.evictExpiredKeys()
+	expiration := c.jitter(time.Now().Add(duration).UnixNano())
+	_, err := bucket.set(key, s2b(&keyStr), strconv.AppendInt(nil, value, 10), expiration)
+	return err == nil
+}
+
 // Remove deletes a key-value pair from the cache.
 func (c *GigaCache) Remove(keyStr string) bool {
+	c.rlock()
+	defer c.runlock()
 	bucket, key := c.getShard(keyStr)
 	bucket.Lock()
 	bucket.evictExpiredKeys()
-	removed := bucket.remove(key)
+	removed := bucket.remove(key, s2b(&keyStr))
 	bucket.Unlock()
 	return removed
 }
 
+// MRemove removes the keys, locking each bucket only once, and returns the number
+// of alive keys removed.
+func (c *GigaCache) MRemove(keys []string) (removed int) {
+	c.rlock()
+	defer c.runlock()
+	groups, hashes := c.groupByShard(keys)
+	for bucket, group := range groups {
+		bucket.Lock()
+		bucket.evictExpiredKeys()
+		for _, i := range group {
+			if bucket.remove(hashes[i], s2b(&keys[i])) {
+				removed++
+			}
+		}
+		bucket.Unlock()
+	}
+	return
+}
+
+// Rename moves the value and expiration of oldKey to newKey, overwriting newKey if exists.
+// It returns false if oldKey is missing or expired, or newKey is rejected.
+func (c *GigaCache) Rename(oldKey, newKey string) bool {
+	c.rlock()
+	defer c.runlock()
+	src, skey := c.getShard(oldKey)
+	dst, dkey := c.getShard(newKey)
+
+	defer c.lockBuckets(src.id, dst.id)()
+
+	value, ts, found := src.get(skey, s2b(&oldKey))
+	if !found {
+		return false
+	}
+	if oldKey == newKey {
+		return true
+	}
+	if _, err := dst.set(dkey, s2b(&newKey), slices.Clone(value), ts); err != nil {
+		return false
+	}
+	src.remove(skey, s2b(&oldKey))
+	return true
+}
+
+// RemovePrefix deletes all key-value pairs whose key has the prefix and returns the count.
+// It visits every bucket under the write lock.
+func (c *GigaCache) RemovePrefix(prefix string) (removed int) {
+	c.rlock()
+	defer c.runlock()
+	p := s2b(&prefix)
+	for _, bucket := range c.buckets {
+		bucket.Lock()
+		removed += bucket.removeIf(func(key []byte) bool {
+			return bytes.HasPrefix(key, p)
+		}, nil)
+		bucket.Unlock()
+	}
+	return
+}
+
 // SetTTL updates the expiration timestamp for a key.
 func (c *GigaCache) SetTTL(keyStr string, expiration int64) bool {
+	c.rlock()
+	defer c.runlock()
 	bucket, key := c.getShard(keyStr)
 	bucket.Lock()
-	success := bucket.setTTL(key, expiration)
+	success := bucket.setTTL(key, s2b(&keyStr), expiration)
 	bucket.evictExpiredKeys()
 	bucket.Unlock()
 	return success
 }
 
+// MSetTTL updates the expiration timestamp for the keys, locking each bucket only once,
+// and returns the number of updated keys. Missing or expired keys are skipped.
+func (c *GigaCache) MSetTTL(keys []string, expiration int64) (updated int) {
+	c.rlock()
+	defer c.runlock()
+	groups, hashes := c.groupByShard(keys)
+	for bucket, group := range groups {
+		bucket.Lock()
+		for _, i := range group {
+			if bucket.setTTL(hashes[i], s2b(&keys[i]), expiration) {
+				updated++
+			}
+		}
+		bucket.evictExpiredKeys()
+		bucket.Unlock()
+	}
+	return
+}
+
 // Walker defines a callback function for iterating over key-value pairs.
 type Walker func(key, value []byte, ttl int64) (continueIteration bool)
 
 // Scan iterates over all alive key-value pairs without copying the data.
 // DO NOT MODIFY the bytes as they are not copied.
 func (c *GigaCache) Scan(callback Walker) {
+	c.rlock()
+	defer c.runlock()
 	for _, bucket := range c.buckets {
 		bucket.RLock()
 		continueIteration := bucket.scan(callback)
@@ -108,8 +838,202 @@ func (c *GigaCache) Scan(callback Walker) {
 	}
 }
 
+// ScanChunked is like Scan, but copies up to chunk pairs under the bucket lock at a time
+// and calls callback on the copies without holding the lock, so writers are blocked only
+// while a chunk is copied. Each bucket is scanned in order of storage over the keys and
+// positions it holds when the scan of it begins, which costs 24 bytes per key of the bucket,
+// so each key is visited at most once. Keys removed meanwhile are skipped, and keys inserted
+// or updated with a value of different size meanwhile, which are moved, are not visited.
+// Automatic migration of the bucket being scanned is postponed, but if it is migrated
+// explicitly or resharded between chunks, its scan restarts and keys may be visited again.
+func (c *GigaCache) ScanChunked(chunk int, callback Walker) {
+	chunk = max(chunk, 1)
+	var pairs [][]byte
+	var ttls []int64
+	var cur *bucket
+	var layout uint64
+	var items []keyPos
+	var next int
+	defer func() {
+		if cur != nil {
+			cur.scanners.Add(-1)
+		}
+	}()
+
+	for b := 0; ; {
+		c.rlock()
+		if b >= len(c.buckets) {
+			c.runlock()
+			return
+		}
+		bucket := c.buckets[b]
+		bucket.RLock()
+		// The cursor is invalid if the bucket is replaced by Reshard or migrated.
+		if bucket != cur {
+			if cur != nil {
+				cur.scanners.Add(-1)
+			}
+			bucket.scanners.Add(1)
+		}
+		if bucket != cur || bucket.layout != layout {
+			cur, layout, next = bucket, bucket.layout, 0
+			items = bucket.positions(items[:0])
+		}
+		pairs, ttls = pairs[:0], ttls[:0]
+		next = bucket.scanChunk(items, next, chunk, func(key, val []byte, ttl int64) {
+			pairs = append(pairs, slices.Clone(key), slices.Clone(val))
+			ttls = append(ttls, ttl)
+		})
+		if next >= len(items) {
+			b++
+		}
+		bucket.RUnlock()
+		c.runlock()
+
+		for j, ttl := range ttls {
+			if !callback(pairs[2*j], pairs[2*j+1], ttl) {
+				return
+			}
+		}
+	}
+}
+
+// ScanCollect runs Scan and returns the results of project for the pairs it selects.
+// project is called under the bucket lock with bytes that are not copied, so it must
+// copy what it keeps.
+func ScanCollect[T any](c *GigaCache, project func(key, value []byte, ttl int64) (T, bool)) (res []T) {
+	c.Scan(func(key, value []byte, ttl int64) bool {
+		if v, ok := project(key, value, ttl); ok {
+			res = append(res, v)
+		}
+		return true
+	})
+	return
+}
+
+// ScanExpired iterates over keys that are expired but not yet evicted, with their expiration time.
+// DO NOT MODIFY the bytes as they are not copied.
+func (c *GigaCache) ScanExpired(fn func(key []byte, expiredAt int64) bool) {
+	c.rlock()
+	defer c.runlock()
+	for _, bucket := range c.buckets {
+		bucket.RLock()
+		continueIteration := bucket.scanExpired(fn)
+		bucket.RUnlock()
+		if !continueIteration {
+			return
+		}
+	}
+}
+
+// ScanContext is like Scan, but stops and returns ctx.Err() once ctx is done.
+// ctx is checked between buckets and every scanCheckInterval pairs within a bucket.
+func (c *GigaCache) ScanContext(ctx context.Context, callback Walker) error {
+	c.rlock()
+	defer c.runlock()
+	var n int
+	for _, bucket := range c.buckets {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var err error
+		bucket.RLock()
+		continueIteration := bucket.scan(func(key, value []byte, ttl int64) bool {
+			if n++; n%scanCheckInterval == 0 {
+				if err = ctx.Err(); err != nil {
+					return false
+				}
+			}
+			return callback(key, value, ttl)
+		})
+		bucket.RUnlock()
+		if err != nil {
+			return err
+		}
+		if !continueIteration {
+			return nil
+		}
+	}
+	return nil
+}
+
+// ScanDelete iterates over all alive key-value pairs under the write lock,
+// and removes those for which fn returns false.
+// DO NOT MODIFY the bytes as they are not copied.
+func (c *GigaCache) ScanDelete(fn func(key, value []byte, ttl int64) (keep bool)) {
+	c.rlock()
+	defer c.runlock()
+	for _, bucket := range c.buckets {
+		bucket.Lock()
+		bucket.removeIf(nil, func(key, value []byte, ttl int64) bool {
+			return !fn(key, value, ttl)
+		})
+		bucket.Unlock()
+	}
+}
+
+// keyHash is a key with its key of index.
+type keyHash struct {
+	keyStr string
+	key    Key
+}
+
+// ScanSorted iterates over all alive key-value pairs in lexicographical order of keys.
+// It buffers a copy of all keys and fetches each value again before calling the Walker,
+// so it is much more expensive than Scan and intended for reproducible dumps.
+func (c *GigaCache) ScanSorted(callback Walker) {
+	var keys []keyHash
+	c.rlock()
+	for _, bucket := range c.buckets {
+		bucket.RLock()
+		bucket.scanHashed(func(key Key, kstr, _ []byte, _ int64) bool {
+			keys = append(keys, keyHash{string(kstr), key})
+			return true
+		})
+		bucket.RUnlock()
+	}
+	c.runlock()
+	slices.SortFunc(keys, func(a, b keyHash) int {
+		return strings.Compare(a.keyStr, b.keyStr)
+	})
+
+	c.rlock()
+	defer c.runlock()
+	for _, kh := range keys {
+		keyStr, key := kh.keyStr, kh.key
+		bucket := c.buckets[c.shardIndex(key)]
+		bucket.RLock()
+		value, ts, found := bucket.get(key, s2b(&keyStr))
+		continueIteration := true
+		if found {
+			continueIteration = callback(s2b(&keyStr), value, ts)
+		}
+		bucket.RUnlock()
+		if !continueIteration {
+			return
+		}
+	}
+}
+
+// TimeWalker defines a callback function for iterating over key-value pairs
+// with the expiration translated into time.Time.
+type TimeWalker func(key, value []byte, expireAt time.Time, hasTTL bool) (continueIteration bool)
+
+// ScanTime is like Scan, but passes the expiration as time.Time.
+// hasTTL is false and expireAt is zero for keys without expiration.
+func (c *GigaCache) ScanTime(callback TimeWalker) {
+	c.Scan(func(key, value []byte, ttl int64) bool {
+		if ttl == noTTL {
+			return callback(key, value, time.Time{}, false)
+		}
+		return callback(key, value, time.Unix(0, ttl), true)
+	})
+}
+
 // Migrate transfers all data to new buckets.
 func (c *GigaCache) Migrate() {
+	c.rlock()
+	defer c.runlock()
 	for _, bucket := range c.buckets {
 		bucket.Lock()
 		bucket.migrate()
@@ -117,40 +1041,315 @@ func (c *GigaCache) Migrate() {
 	}
 }
 
-// EvictExpiredKeys
+// MigrateBucket transfers the data of the bucket at index to a new container.
+func (c *GigaCache) MigrateBucket(index int) error {
+	c.rlock()
+	defer c.runlock()
+	if index < 0 || index >= len(c.buckets) {
+		return errBucketIndex
+	}
+	bucket := c.buckets[index]
+	bucket.Lock()
+	bucket.migrate()
+	bucket.Unlock()
+	return nil
+}
+
+// MigrateFragmented migrates only the buckets reaching MigrateRatio and MigrateMinBytes,
+// and returns the number of migrated buckets.
+func (c *GigaCache) MigrateFragmented() (migrated int) {
+	c.rlock()
+	defer c.runlock()
+	for _, bucket := range c.buckets {
+		bucket.Lock()
+		if bucket.migrateIfNeeded() {
+			migrated++
+		}
+		bucket.Unlock()
+	}
+	return
+}
+
+// Compact defragments all buckets in-place. Unlike Migrate, it does not allocate
+// a new data container, but a scratch space of 32 bytes per key, which lowers the peak
+// memory during compaction unless entries are about as small. It falls back to Migrate
+// if VerifyChecksum is enabled.
+func (c *GigaCache) Compact() {
+	c.rlock()
+	defer c.runlock()
+	for _, bucket := range c.buckets {
+		bucket.Lock()
+		bucket.compact()
+		bucket.Unlock()
+	}
+}
+
+// Shrink migrates all buckets and reallocates data whose capacity greatly exceeds its length,
+// so that memory of a bucket that has grown large and then lost most keys is returned.
+func (c *GigaCache) Shrink() {
+	c.rlock()
+	defer c.runlock()
+	for _, bucket := range c.buckets {
+		bucket.Lock()
+		bucket.shrink()
+		bucket.Unlock()
+	}
+}
+
+// Reshard redistributes all alive key-value pairs into newShardCount new buckets,
+// it must be a power of two, or 0 to resolve it automatically.
+// Keys are not rehashed, but it is still expensive and the runtime statistics are reset.
+// It waits for in-flight calls and blocks other calls until done, see Reshardable.
+// It returns an error if Reshardable is disabled.
+func (c *GigaCache) Reshard(newShardCount uint32) error {
+	if !c.options.Reshardable {
+		return errors.New("cache: Reshard requires Reshardable option")
+	}
+	c.reshardMu.Lock()
+	defer c.reshardMu.Unlock()
+	options := c.options
+	options.ShardCount = newShardCount
+	if err := validateOptions(options); err != nil {
+		return err
+	}
+	if options.ShardCount == 0 {
+		options.ShardCount = autoShardCount()
+	}
+
+	mask := options.ShardCount - 1
+	buckets := make([]*bucket, options.ShardCount)
+	for i := range buckets {
+		buckets[i] = newBucket(i, options)
+	}
+
+	nanosec := time.Now().UnixNano()
+	for _, old := range c.buckets {
+		old.Lock()
+		old.index.All(func(key Key, idx Idx) bool {
+			nb := buckets[shardOf(key, mask)]
+			if idx.expiredWith(nanosec) {
+				// notified by the old bucket, counted by the new one as statistics are reset.
+				old.notifyEvicted(idx)
+				nb.evictions++
+				return true
+			}
+			entry, _, _ := old.findEntry(idx)
+			nb.index.Put(key, newIdxx(len(nb.data), idx))
+			nb.data = append(nb.data, entry...)
+			nb.padded += uint64(old.padding(idx))
+			return true
+		})
+		old.Unlock()
+	}
+	for _, nb := range buckets {
+		nb.peakAlloc = len(nb.data)
+	}
+
+	c.options.ShardCount = options.ShardCount
+	c.mask = mask
+	c.buckets = buckets
+	return nil
+}
+
+// EvictExpiredKeys evicts expired keys of the next bucket in round-robin order,
+// so that repeated calls cover all buckets fairly.
 func (c *GigaCache) EvictExpiredKeys() {
-	id := rand.IntN(len(c.buckets))
-	bucket := c.buckets[id]
+	c.rlock()
+	defer c.runlock()
+	bucket := c.buckets[c.nextEvictBucket()]
 	bucket.Lock()
 	bucket.evictExpiredKeys(true)
 	bucket.Unlock()
 }
 
+// nextEvictBucket advances evictCursor and returns the bucket index to sweep.
+func (c *GigaCache) nextEvictBucket() uint32 {
+	return (c.evictCursor.Add(1) - 1) & c.mask
+}
+
+// DeleteExpired removes expired key-value pairs across all buckets and returns the total count.
+func (c *GigaCache) DeleteExpired() (removed int) {
+	c.rlock()
+	defer c.runlock()
+	for _, bucket := range c.buckets {
+		bucket.Lock()
+		removed += bucket.deleteExpired()
+		bucket.Unlock()
+	}
+	return
+}
+
+// LiveLen returns the number of unexpired key-value pairs by scanning all buckets.
+// Unlike Stats.Len, it excludes expired keys that have not been evicted yet.
+func (c *GigaCache) LiveLen() (n int) {
+	c.rlock()
+	defer c.runlock()
+	for _, bucket := range c.buckets {
+		bucket.RLock()
+		n += bucket.liveLen()
+		bucket.RUnlock()
+	}
+	return
+}
+
+// EvictWithBudget sweeps expired keys bucket by bucket until the time budget d elapses
+// or all buckets are visited, and returns the number of keys removed.
+// The next call resumes from the bucket where the last one stopped.
+func (c *GigaCache) EvictWithBudget(d time.Duration) (removed int) {
+	c.rlock()
+	defer c.runlock()
+	start := time.Now()
+	for range c.buckets {
+		bucket := c.buckets[c.nextEvictBucket()]
+		bucket.Lock()
+		removed += bucket.deleteExpired()
+		bucket.Unlock()
+		if time.Since(start) >= d {
+			return
+		}
+	}
+	return
+}
+
 // Stats represents the runtime statistics of GigaCache.
 type Stats struct {
-	Len       int
-	Alloc     uint64
-	Unused    uint64
-	Migrates  uint64
-	Evictions uint64
-	Probes    uint64
+	// Len includes expired keys that have not been evicted yet, see LiveLen.
+	Len   int
+	Alloc uint64
+	// PeakAlloc is the sum of the high-water marks of Alloc of each bucket. The buckets may
+	// peak at different times, so it is an upper bound of the peak Alloc of the cache.
+	PeakAlloc         uint64
+	Unused            uint64
+	IndexBytes        uint64 // estimated memory of indexes, not included in Alloc.
+	Migrates          uint64
+	Evictions         uint64 // sum of ExpiredEvictions and CapacityEvictions.
+	ExpiredEvictions  uint64 // evictions of expired keys.
+	CapacityEvictions uint64 // evictions of alive keys to make room, see MaxEntriesPerBucket.
+	Probes            uint64
+	DroppedEvictions  uint64 // evictions not sent to a full EvictChannel.
+	Padding           uint64 // bytes reserved by PadValues for alive entries, included in Alloc.
+	Rejections        uint64 // new keys rejected by FullReject.
+	ChecksumFailures  uint64 // reads of corrupted entries, see VerifyChecksum.
 }
 
 // GetStats returns the current runtime statistics of GigaCache.
-func (c *GigaCache) GetStats() (stats Stats) {
+func (c *GigaCache) GetStats() Stats {
+	c.rlock()
+	defer c.runlock()
+	return c.stats()
+}
+
+// stats is like GetStats, reshardMu must be held.
+func (c *GigaCache) stats() (stats Stats) {
 	for _, bucket := range c.buckets {
 		bucket.RLock()
 		stats.Len += bucket.index.Len()
+		stats.IndexBytes += indexBytes(bucket.index.Len())
 		stats.Alloc += uint64(len(bucket.data))
-		stats.Unused += uint64(bucket.unused)
+		stats.PeakAlloc += uint64(bucket.peakAlloc)
+		stats.Unused += bucket.unused
 		stats.Migrates += uint64(bucket.migrations)
 		stats.Evictions += bucket.evictions
+		stats.ExpiredEvictions += bucket.evictions - bucket.capEvicts
+		stats.CapacityEvictions += bucket.capEvicts
 		stats.Probes += bucket.probes
+		stats.DroppedEvictions += bucket.dropped
+		stats.Padding += bucket.padded
+		stats.Rejections += bucket.rejections
+		stats.ChecksumFailures += bucket.checksumFailures.Load()
 		bucket.RUnlock()
 	}
 	return
 }
 
+// ResetStats resets the counters of runtime statistics, Len, Alloc and Unused are not affected.
+func (c *GigaCache) ResetStats() {
+	c.rlock()
+	defer c.runlock()
+	for _, bucket := range c.buckets {
+		bucket.Lock()
+		bucket.resetStats()
+		bucket.Unlock()
+	}
+}
+
+// BucketStats represents the runtime statistics of a single bucket.
+type BucketStats struct {
+	Index         int
+	Len           int
+	Alloc         uint64
+	Unused        uint64
+	Migrates      uint64
+	Evictions     uint64
+	Probes        uint64
+	LockWaitNanos int64
+}
+
+// GetBucketStats returns the current runtime statistics of each bucket.
+func (c *GigaCache) GetBucketStats() []BucketStats {
+	c.rlock()
+	defer c.runlock()
+	stats := make([]BucketStats, 0, len(c.buckets))
+	for i, bucket := range c.buckets {
+		bucket.RLock()
+		stats = append(stats, BucketStats{
+			Index:         i,
+			Len:           bucket.index.Len(),
+			Alloc:         uint64(len(bucket.data)),
+			Unused:        bucket.unused,
+			Migrates:      uint64(bucket.migrations),
+			Evictions:     bucket.evictions,
+			Probes:        bucket.probes,
+			LockWaitNanos: bucket.lockWaitNanos(),
+		})
+		bucket.RUnlock()
+	}
+	return stats
+}
+
+// ShardHealth represents the fragmentation of a single bucket.
+type ShardHealth struct {
+	Index        int
+	Len          int
+	UnusedRate   float64 // percentage of unused space in data.
+	NeedsMigrate bool    // whether unused space reaches MigrateRatio and MigrateMinBytes.
+}
+
+// Health returns the fragmentation of each bucket, to find buckets that need migration.
+func (c *GigaCache) Health() []ShardHealth {
+	c.rlock()
+	defer c.runlock()
+	health := make([]ShardHealth, 0, len(c.buckets))
+	for i, bucket := range c.buckets {
+		bucket.RLock()
+		h := ShardHealth{
+			Index:        i,
+			Len:          bucket.index.Len(),
+			NeedsMigrate: bucket.needsMigrate(),
+		}
+		if len(bucket.data) > 0 {
+			h.UnusedRate = float64(bucket.unused) / float64(len(bucket.data)) * 100
+		}
+		health = append(health, h)
+		bucket.RUnlock()
+	}
+	return health
+}
+
+// String returns a one-line summary of the statistics for logging.
+func (s Stats) String() string {
+	var unusedRate, evictionRate float64
+	if s.Alloc > 0 {
+		unusedRate = s.UnusedRate()
+	}
+	if s.Probes > 0 {
+		evictionRate = s.EvictionRate()
+	}
+	return fmt.Sprintf("len: %d | alloc: %s (unused: %.1f%%) | index: %s | evict: %d/%d (%.1f%%) | migrates: %d",
+		s.Len, formatSize(s.Alloc), unusedRate, formatSize(s.IndexBytes),
+		s.Evictions, s.Probes, evictionRate, s.Migrates)
+}
+
 // UnusedRate calculates the percentage of unused space in the cache.
 func (s Stats) UnusedRate() float64 {
 	return float64(s.Unused) / float64(s.Alloc) * 100
@@ -160,3 +1359,16 @@ func (s Stats) UnusedRate() float64 {
 func (s Stats) EvictionRate() float64 {
 	return float64(s.Evictions) / float64(s.Probes) * 100
 }
+
+// AvgEntrySize calculates the average allocated bytes per key, or 0 if the cache is empty.
+func (s Stats) AvgEntrySize() float64 {
+	if s.Len == 0 {
+		return 0
+	}
+	return float64(s.Alloc) / float64(s.Len)
+}
+
+// LiveBytes calculates the bytes of data still in use.
+func (s Stats) LiveBytes() uint64 {
+	return s.Alloc - s.Unused
+}
@@ -48,3 +48,28 @@ func FuzzCacheHashConflict(f *testing.F) {
 		}
 	})
 }
+
+func FuzzBucketVerifyKeys(f *testing.F) {
+	options := DefaultOptions
+	options.VerifyKeys = true
+	b := newBucket(0, options)
+
+	// all keys share the same hash to force conflicts.
+	key := hashFn("conflict")
+
+	f.Fuzz(func(t *testing.T, k1, k2 string, val []byte) {
+		assert := assert.New(t)
+
+		b.set(key, []byte(k1), val, 0)
+
+		res, _, ok := b.get(key, []byte(k1))
+		assert.True(ok)
+		assert.Equal(string(val), string(res))
+
+		res, _, ok = b.get(key, []byte(k2))
+		assert.Equal(k1 == k2, ok)
+		if !ok {
+			assert.Nil(res)
+		}
+	})
+}
@@ -1,7 +1,13 @@
 package cache
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"math"
+	"runtime"
+	"slices"
+	"sync"
 	"testing"
 	"time"
 
@@ -126,6 +132,9 @@ func TestCache(t *testing.T) {
 		m.Migrate()
 		checkValidData(assert, m, 0, num/3)
 		checkInvalidData(assert, m, num/3, num)
+		m.Compact()
+		checkValidData(assert, m, 0, num/3)
+		checkInvalidData(assert, m, num/3, num)
 	}
 
 	// remove all.
@@ -147,11 +156,54 @@ func TestCache(t *testing.T) {
 
 	assert.Panics(func() {
 		opt := DefaultOptions
-		opt.ShardCount = 0
+		opt.IndexSize = -1
+		New(opt)
+	})
+
+	assert.Panics(func() {
+		opt := DefaultOptions
+		opt.ShardCount = 1000
 		New(opt)
 	})
 }
 
+func TestAutoShardCount(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 0
+	m := New(opt)
+
+	n := m.ShardCount()
+	assert.GreaterOrEqual(n, minShardCount)
+	assert.LessOrEqual(n, maxShardCount)
+	assert.Equal(n&(n-1), 0)
+
+	m.Set("foo", []byte("bar"))
+	val, _, ok := m.Get("foo")
+	assert.True(ok)
+	assert.Equal(val, []byte("bar"))
+}
+
+func TestTotalIndexSize(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 16
+	assert.Equal(opt.indexSize(), 1024)
+
+	opt.TotalIndexSize = 1000
+	assert.Equal(opt.indexSize(), 63)
+
+	m := New(opt)
+	for i := 0; i < 1000; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+	assert.Equal(m.GetStats().Len, 1000)
+
+	opt.TotalIndexSize = -1
+	assert.Panics(func() { New(opt) })
+}
+
 func TestEvict(t *testing.T) {
 	assert := assert.New(t)
 	const num = 1000
@@ -181,6 +233,7 @@ func TestEvict(t *testing.T) {
 	stat = m.GetStats()
 	assert.Equal(stat.Len, 1)
 	assert.Equal(stat.Alloc, uint64(16+2))
+	assert.Equal(stat.PeakAlloc, uint64(num*(16+2)))
 	assert.Equal(stat.Unused, uint64(0))
 	assert.Equal(stat.Migrates, uint64(1))
 }
@@ -217,6 +270,11 @@ func TestDataAlloc(t *testing.T) {
 		assert.Equal(stat.Len, 2)
 		assert.Equal(stat.Alloc, uint64(12+8+10))
 		assert.Equal(stat.Unused, uint64(8))
+		assert.Equal(stat.LiveBytes(), uint64(12+10))
+		assert.Equal(stat.AvgEntrySize(), float64(12+8+10)/2)
+
+		// empty.
+		assert.Equal(Stats{}.AvgEntrySize(), float64(0))
 	})
 }
 
@@ -240,7 +298,99 @@ func TestScanSmall(t *testing.T) {
 }
 
 func TestUtils(t *testing.T) {
+	assert := assert.New(t)
 	_ = SizeUvarint(1)
+
+	assert.Equal(formatSize(100), "100B")
+	assert.Equal(formatSize(1536), "1.5KB")
+	assert.Equal(formatSize(3*KB*KB), "3.0MB")
+}
+
+func TestStatsString(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+	assert.Equal(m.GetStats().String(), "len: 0 | alloc: 0B (unused: 0.0%) | index: 0B | evict: 0/0 (0.0%) | migrates: 0")
+
+	stats := Stats{Len: 100, Alloc: 2048, Unused: 512, IndexBytes: 3300, Evictions: 1, Probes: 4, Migrates: 2}
+	assert.Equal(stats.String(), "len: 100 | alloc: 2.0KB (unused: 25.0%) | index: 3.2KB | evict: 1/4 (25.0%) | migrates: 2")
+}
+
+func TestLazyExpiryOnGet(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.EvictInterval = -1
+	m := New(opt)
+
+	ts := time.Now().Add(-time.Second).UnixNano()
+	m.SetTx("foo", []byte("bar"), ts)
+	_, _, ok := m.Get("foo")
+	assert.False(ok)
+	assert.Equal(m.GetStats().Len, 1)
+
+	opt.LazyExpiryOnGet = true
+	m = New(opt)
+	m.SetTx("foo", []byte("bar"), ts)
+	m.Set("alive", []byte("bar"))
+	_, _, ok = m.Get("foo")
+	assert.False(ok)
+	_, _, ok = m.Get("alive")
+	assert.True(ok)
+	_, _, ok = m.Get("none")
+	assert.False(ok)
+
+	stats := m.GetStats()
+	assert.Equal(stats.Len, 1)
+	assert.Equal(stats.Evictions, uint64(1))
+}
+
+func TestMRemove(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.EvictInterval = -1
+	m := New(opt)
+
+	keys := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+		keys = append(keys, k)
+	}
+	m.SetTx("expired", []byte("bar"), time.Now().Add(-time.Second).UnixNano())
+
+	// missing, expired and duplicate keys are not counted.
+	n := m.MRemove(append(keys[:50:50], "none", "expired", keys[0]))
+	assert.Equal(n, 50)
+	assert.Equal(m.GetStats().Len, 50)
+	for i, k := range keys {
+		_, _, ok := m.Get(k)
+		assert.Equal(ok, i >= 50)
+	}
+	assert.Equal(m.MRemove(nil), 0)
+}
+
+func TestMSetTTL(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.EvictInterval = -1
+	m := New(opt)
+
+	keys := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+		keys = append(keys, k)
+	}
+	m.SetTx("expired", []byte("bar"), time.Now().Add(-time.Second).UnixNano())
+
+	ts := time.Now().Add(time.Hour).UnixNano()
+	assert.Equal(m.MSetTTL(append(keys, "none", "expired"), ts), 100)
+	for _, k := range keys {
+		_, ttl, ok := m.Get(k)
+		assert.True(ok)
+		assert.Equal(ttl, ts)
+	}
+	_, _, ok := m.Get("expired")
+	assert.False(ok)
 }
 
 func TestHSetNewField(t *testing.T) {
@@ -277,4 +427,2217 @@ func TestEvictManual(t *testing.T) {
 	stat = m.GetStats()
 	assert.Equal(stat.Len, 1)
 	assert.Equal(stat.Evictions, uint64(1))
+
+	m.Migrate()
+	m.ResetStats()
+	stat = m.GetStats()
+	assert.Equal(stat.Len, 1)
+	assert.Equal(stat.Alloc, uint64(10))
+	assert.Equal(stat.Evictions, uint64(0))
+	assert.Equal(stat.Probes, uint64(0))
+	assert.Equal(stat.Migrates, uint64(0))
+}
+
+func TestScanTime(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	deadline := time.Now().Add(time.Hour)
+	m.Set("foo", []byte("bar"))
+	m.SetTx("ttl", []byte("bar"), deadline.UnixNano())
+
+	var count int
+	m.ScanTime(func(key, val []byte, expireAt time.Time, hasTTL bool) bool {
+		switch string(key) {
+		case "foo":
+			assert.False(hasTTL)
+			assert.True(expireAt.IsZero())
+		case "ttl":
+			assert.True(hasTTL)
+			assert.Equal(expireAt.UnixNano(), deadline.UnixNano())
+		}
+		count++
+		return true
+	})
+	assert.Equal(count, 2)
+}
+
+func TestScanSorted(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	for i := 99; i >= 0; i-- {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+
+	var count int
+	m.ScanSorted(func(key, val []byte, ttl int64) bool {
+		k, v := genKV(count)
+		assert.Equal(string(key), k)
+		assert.Equal(val, v)
+		count++
+		return true
+	})
+	assert.Equal(count, 100)
+
+	// break
+	count = 0
+	m.ScanSorted(func(key, val []byte, ttl int64) bool {
+		count++
+		return count < 10
+	})
+	assert.Equal(count, 10)
+}
+
+func TestDeleteExpired(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 4
+	opt.EvictInterval = -1
+	m := New(opt)
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		if i%2 == 0 {
+			m.SetEx(k, v, time.Millisecond)
+		} else {
+			m.Set(k, v)
+		}
+	}
+	time.Sleep(time.Millisecond * 10)
+
+	assert.Equal(m.GetStats().Len, 100)
+	assert.Equal(m.LiveLen(), 50)
+
+	assert.Equal(m.DeleteExpired(), 50)
+	assert.Equal(m.DeleteExpired(), 0)
+
+	stat := m.GetStats()
+	assert.Equal(stat.Len, 50)
+	assert.Equal(stat.Evictions, uint64(50))
+}
+
+func TestOnMigrate(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 1
+
+	var calls, before, after int
+	opt.OnMigrate = func(bucketIndex, b, a int) {
+		assert.Equal(bucketIndex, 0)
+		calls++
+		before, after = b, a
+	}
+	m := New(opt)
+
+	m.Set("hello", []byte("world"))
+	m.Set("abc", []byte("123"))
+	m.Remove("hello")
+	m.Migrate()
+
+	assert.Equal(calls, 1)
+	assert.Equal(before, 12+8)
+	assert.Equal(after, 8)
+}
+
+func TestOnMigrateDone(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 1
+
+	var calls, entries, bytes int
+	opt.OnMigrateDone = func(bucketIndex, e, b int, took time.Duration) {
+		assert.Equal(bucketIndex, 0)
+		assert.GreaterOrEqual(took, time.Duration(0))
+		calls++
+		entries, bytes = e, b
+	}
+	m := New(opt)
+
+	m.Set("hello", []byte("world"))
+	m.Set("abc", []byte("123"))
+	m.Set("foo", []byte("bar"))
+	m.Remove("hello")
+	m.Migrate()
+
+	assert.Equal(calls, 1)
+	assert.Equal(entries, 2)
+	assert.Equal(bytes, 16)
+
+	// compact copies only the entries after the removed ones.
+	m.Set("hello", []byte("world"))
+	m.Remove("abc")
+	m.Remove("foo")
+	m.Compact()
+	assert.Equal(calls, 2)
+	assert.Equal(entries, 1)
+	assert.Equal(bytes, 12)
+
+	// took excludes the callbacks.
+	opt.OnMigrate = func(int, int, int) { time.Sleep(50 * time.Millisecond) }
+	opt.OnMigrateDone = func(_, _, _ int, took time.Duration) {
+		assert.Less(took, 50*time.Millisecond)
+		calls++
+	}
+	m = New(opt)
+	m.Set("foo", []byte("bar"))
+	m.Migrate()
+	m.Compact()
+	assert.Equal(calls, 4)
+}
+
+func TestBucketStats(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 4
+	opt.TrackLockWait = true
+	m := New(opt)
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+
+	var count int
+	stats := m.GetBucketStats()
+	assert.Equal(len(stats), 4)
+	for i, s := range stats {
+		assert.Equal(s.Index, i)
+		assert.GreaterOrEqual(s.LockWaitNanos, int64(0))
+		count += s.Len
+	}
+	assert.Equal(count, 100)
+
+	// disabled.
+	m = New(DefaultOptions)
+	for _, s := range m.GetBucketStats() {
+		assert.Equal(s.LockWaitNanos, int64(0))
+	}
+}
+
+func TestHealth(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 2
+	opt.EvictInterval = -1
+	m := New(opt)
+
+	for _, h := range m.Health() {
+		assert.Equal(h.UnusedRate, float64(0))
+		assert.False(h.NeedsMigrate)
+	}
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+	for i := 0; i < 80; i++ {
+		k, _ := genKV(i)
+		m.Remove(k)
+	}
+
+	health := m.Health()
+	assert.Equal(len(health), 2)
+	var count int
+	for i, h := range health {
+		assert.Equal(h.Index, i)
+		assert.Greater(h.UnusedRate, float64(40))
+		assert.True(h.NeedsMigrate)
+		count += h.Len
+	}
+	assert.Equal(count, 20)
+
+	m.Migrate()
+	for _, h := range m.Health() {
+		assert.Equal(h.UnusedRate, float64(0))
+		assert.False(h.NeedsMigrate)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+	m.SetEx("expired", []byte("bar"), time.Millisecond)
+	time.Sleep(time.Millisecond * 10)
+
+	snap := m.Snapshot()
+
+	// mutate the live cache.
+	for i := 0; i < 100; i++ {
+		k, _ := genKV(i)
+		m.Set(k, []byte("updated"))
+	}
+	m.Migrate()
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		val, ts, ok := snap.Get(k)
+		assert.True(ok)
+		assert.Equal(val, v)
+		assert.Equal(ts, int64(0))
+	}
+	_, _, ok := snap.Get("expired")
+	assert.False(ok)
+
+	var count int
+	snap.Scan(func(key, val []byte, ttl int64) bool {
+		assert.Equal(key, val)
+		count++
+		return true
+	})
+	assert.Equal(count, 100)
+}
+
+func TestGetWithAge(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 1
+	opt.EvictInterval = -1
+	opt.TrackCreation = true
+	m := New(opt)
+
+	m.Set("foo", []byte("bar"))
+	m.Set("hello", []byte("world"))
+
+	val, age, ok := m.GetWithAge("foo")
+	assert.True(ok)
+	assert.Equal(val, []byte("bar"))
+	assert.GreaterOrEqual(age, time.Duration(0))
+	assert.Less(age, 2*time.Second)
+
+	// creation time is preserved after update and migration.
+	bucket, key := m.getShard("foo")
+	idx, _ := bucket.index.Get(key)
+	bucket.putCreated(idx, time.Now().Add(-time.Hour).Unix())
+
+	m.Set("foo", []byte("bar2"))
+	m.Remove("hello")
+	m.Migrate()
+
+	val, age, ok = m.GetWithAge("foo")
+	assert.True(ok)
+	assert.Equal(val, []byte("bar2"))
+	assert.GreaterOrEqual(age, time.Hour)
+
+	_, _, ok = m.GetWithAge("hello")
+	assert.False(ok)
+
+	count := 0
+	m.Scan(func(key, val []byte, _ int64) bool {
+		assert.Equal(string(key), "foo")
+		assert.Equal(string(val), "bar2")
+		count++
+		return true
+	})
+	assert.Equal(count, 1)
+}
+
+func TestRename(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 4
+	m := New(opt)
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.SetEx(k, v, time.Hour)
+	}
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		_, ts, _ := m.Get(k)
+		assert.True(m.Rename(k, "new"+k))
+
+		_, _, ok := m.Get(k)
+		assert.False(ok)
+		val, ttl, ok := m.Get("new" + k)
+		assert.True(ok)
+		assert.Equal(val, v)
+		assert.Equal(ttl, ts)
+	}
+	assert.Equal(m.GetStats().Len, 100)
+
+	// same key.
+	assert.True(m.Rename("new00000001", "new00000001"))
+	// not exist.
+	assert.False(m.Rename("none", "foo"))
+	// expired.
+	m.SetEx("expired", []byte("bar"), time.Millisecond)
+	time.Sleep(time.Millisecond * 10)
+	assert.False(m.Rename("expired", "foo"))
+}
+
+func TestLockBuckets(t *testing.T) {
+	opt := DefaultOptions
+	opt.ShardCount = 4
+	m := New(opt)
+
+	// opposite orders and duplicates must not deadlock.
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			m.lockBuckets(0, 3, 1)()
+		}()
+		go func() {
+			defer wg.Done()
+			m.lockBuckets(3, 1, 1, 0)()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestTTLJitter(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.TTLJitter = time.Second
+	m := New(opt)
+
+	ts := time.Now().Add(time.Hour).UnixNano()
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.SetTx(k, v, ts)
+	}
+	m.Set("nottl", []byte("bar"))
+
+	var jittered int
+	m.Scan(func(key, _ []byte, ttl int64) bool {
+		if string(key) == "nottl" {
+			assert.Equal(ttl, int64(0))
+			return true
+		}
+		assert.GreaterOrEqual(ttl, ts)
+		assert.Less(ttl, ts+int64(time.Second))
+		if ttl != ts {
+			jittered++
+		}
+		return true
+	})
+	assert.Greater(jittered, 0)
+}
+
+func TestWarmUp(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 4
+	m := New(opt)
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+	m.WarmUp(100000)
+
+	for _, bucket := range m.buckets {
+		assert.GreaterOrEqual(cap(bucket.data), 100000/4*18)
+	}
+	checkValidData(assert, m, 0, 100)
+
+	for i := 100; i < 1000; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+	checkValidData(assert, m, 0, 1000)
+
+	// empty cache falls back to the default entry size.
+	m = New(opt)
+	m.WarmUp(100000)
+	for _, bucket := range m.buckets {
+		assert.GreaterOrEqual(cap(bucket.data), 100000/4*defaultWarmUpEntrySize)
+	}
+}
+
+func TestGetUnsafe(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	ts := time.Now().Add(time.Hour).UnixNano()
+	m.SetTx("foo", []byte("bar"), ts)
+
+	var calls int
+	ok := m.GetUnsafe("foo", func(value []byte, ttl int64) {
+		assert.Equal(value, []byte("bar"))
+		assert.Equal(ttl, ts)
+		calls++
+	})
+	assert.True(ok)
+
+	ok = m.GetUnsafe("none", func([]byte, int64) {
+		calls++
+	})
+	assert.False(ok)
+	assert.Equal(calls, 1)
+}
+
+func TestEvictWithBudget(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 8
+	opt.EvictInterval = -1
+	m := New(opt)
+
+	for i := 0; i < 1000; i++ {
+		k, v := genKV(i)
+		m.SetEx(k, v, time.Millisecond)
+	}
+	time.Sleep(time.Millisecond * 10)
+
+	// sweep one bucket per call.
+	var removed int
+	for i := 0; i < 8; i++ {
+		n := m.EvictWithBudget(0)
+		assert.Greater(n, 0)
+		removed += n
+	}
+	assert.Equal(removed, 1000)
+	assert.Equal(m.EvictWithBudget(time.Second), 0)
+	assert.Equal(m.GetStats().Len, 0)
+}
+
+func TestVerifyKeys(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 1
+	opt.VerifyKeys = true
+	m := New(opt)
+
+	m.Set("foo", []byte("bar"))
+	val, _, ok := m.Get("foo")
+	assert.True(ok)
+	assert.Equal(val, []byte("bar"))
+
+	// simulate a hash conflict by storing another key under the hash of "foo".
+	bucket, key := m.getShard("foo")
+	bucket.set(key, []byte("xyz"), []byte("bar"), 0)
+
+	_, _, ok = m.Get("foo")
+	assert.False(ok)
+	_, _, ok = m.GetWithAge("foo")
+	assert.False(ok)
+
+	// writes do not act on the conflicting key.
+	assert.False(m.SetTTL("foo", time.Now().Add(time.Hour).UnixNano()))
+	assert.Equal(m.MSetTTL([]string{"foo"}, time.Now().Add(time.Hour).UnixNano()), 0)
+	assert.False(m.Remove("foo"))
+	assert.Equal(m.MRemove([]string{"foo"}), 0)
+	idx, ok := bucket.index.Get(key)
+	assert.True(ok)
+	assert.Equal(idx.lo, int64(noTTL))
+	assert.Equal(m.GetStats().Len, 1)
+}
+
+func TestSetManyTx(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	var keys []string
+	var values [][]byte
+	var expirations []int64
+	ts := time.Now().Add(time.Hour).UnixNano()
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		keys = append(keys, k)
+		values = append(values, v)
+		expirations = append(expirations, ts+int64(i))
+	}
+	assert.Nil(m.SetManyTx(keys, values, expirations))
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		val, ttl, ok := m.Get(k)
+		assert.True(ok)
+		assert.Equal(val, v)
+		assert.Equal(ttl, ts+int64(i))
+	}
+
+	assert.NotNil(m.SetManyTx(keys, values[1:], expirations))
+	assert.NotNil(m.SetManyTx(keys, values, expirations[1:]))
+}
+
+func TestGetMulti(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	var keys []string
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		if i%2 == 0 {
+			m.Set(k, v)
+		}
+		keys = append(keys, k)
+	}
+
+	var hits, misses int
+	m.GetMulti(keys, func(key string, value []byte, ttl int64, ok bool) {
+		if ok {
+			assert.Equal(key, string(value))
+			hits++
+		} else {
+			assert.Nil(value)
+			misses++
+		}
+	})
+	assert.Equal(hits, 50)
+	assert.Equal(misses, 50)
+}
+
+func TestGetBatchMap(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	keys := []string{"none", "expired"}
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+		keys = append(keys, k)
+	}
+	m.SetTx("expired", []byte("bar"), time.Now().Add(-time.Second).UnixNano())
+
+	result := m.GetBatchMap(keys)
+	assert.Equal(len(result), 100)
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		assert.Equal(result[k], v)
+	}
+	_, ok := result["none"]
+	assert.False(ok)
+	assert.Equal(len(m.GetBatchMap(nil)), 0)
+}
+
+func TestMigrateMinBytes(t *testing.T) {
+	assert := assert.New(t)
+	const num = 100
+	opt := getOptions(num, 1)
+	opt.MigrateMinBytes = num * 18
+	m := New(opt)
+
+	for i := 0; i < num; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+	// unused rate is high but unused bytes are not enough.
+	for i := 0; i < num/2; i++ {
+		k, _ := genKV(i)
+		m.Remove(k)
+	}
+	stat := m.GetStats()
+	assert.Equal(stat.Migrates, uint64(0))
+	assert.Equal(stat.Unused, uint64(num/2*18))
+
+	for i := num / 2; i < num; i++ {
+		k, _ := genKV(i)
+		m.Remove(k)
+	}
+	// trig evict.
+	m.Set("trig1234", []byte("trig1234"))
+
+	stat = m.GetStats()
+	assert.Equal(stat.Migrates, uint64(1))
+	assert.Equal(stat.Alloc, uint64(18))
+	assert.Equal(stat.Unused, uint64(0))
+}
+
+func TestReuseMigrateBuffer(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 1
+	opt.EvictInterval = -1
+	opt.ReuseMigrateBuffer = true
+	m := New(opt)
+
+	for i := 0; i < 1000; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+	bucket := m.buckets[0]
+	m.Migrate()
+	first := bucket.data
+	m.Migrate()
+	assert.Equal(len(bucket.spare), len(first))
+	assert.True(&bucket.spare[0] == &first[0])
+
+	// the spare buffer of the previous migration is reused.
+	m.Migrate()
+	assert.True(&bucket.data[0] == &first[0])
+
+	for i := 0; i < 1000; i++ {
+		k, v := genKV(i)
+		val, _, ok := m.Get(k)
+		assert.True(ok)
+		assert.Equal(val, v)
+	}
+}
+
+func TestShrink(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 1
+	opt.EvictInterval = -1
+	m := New(opt)
+
+	for i := 0; i < 10000; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+	for i := 100; i < 10000; i++ {
+		k, _ := genKV(i)
+		m.Remove(k)
+	}
+	bucket := m.buckets[0]
+	before := len(bucket.data)
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	allocated := stats.TotalAlloc
+	m.Shrink()
+	assert.Equal(cap(bucket.data), len(bucket.data))
+	assert.Equal(m.GetStats().Unused, uint64(0))
+
+	// no buffer of the old length is allocated.
+	runtime.ReadMemStats(&stats)
+	assert.Less(stats.TotalAlloc-allocated, uint64(before/2))
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		val, _, ok := m.Get(k)
+		assert.True(ok)
+		assert.Equal(val, v)
+	}
+
+	// empty bucket.
+	for i := 0; i < 100; i++ {
+		k, _ := genKV(i)
+		m.Remove(k)
+	}
+	m.Shrink()
+	assert.Equal(cap(bucket.data), 0)
+	m.Set("foo", []byte("bar"))
+	val, _, _ := m.Get("foo")
+	assert.Equal(val, []byte("bar"))
+}
+
+func TestCompact(t *testing.T) {
+	assert := assert.New(t)
+	const num = 1000
+	opt := getOptions(num, -1)
+	m := New(opt)
+
+	for i := 0; i < num; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+	for i := 0; i < num; i += 2 {
+		k, _ := genKV(i)
+		m.Remove(k)
+	}
+	// update with larger value.
+	m.Set("00000001", []byte("00000001-00000001"))
+
+	capacity := cap(m.buckets[0].data)
+	m.Compact()
+
+	stat := m.GetStats()
+	assert.Equal(stat.Len, num/2)
+	assert.Equal(stat.Unused, uint64(0))
+	assert.Equal(stat.Alloc, uint64((num/2)*18+9))
+	assert.Equal(stat.Migrates, uint64(1))
+	assert.Equal(cap(m.buckets[0].data), capacity)
+
+	val, _, ok := m.Get("00000001")
+	assert.True(ok)
+	assert.Equal(string(val), "00000001-00000001")
+	for i := 3; i < num; i += 2 {
+		k, v := genKV(i)
+		val, _, ok := m.Get(k)
+		assert.True(ok)
+		assert.Equal(val, v)
+	}
+
+	// keys stored by hashes other than xxh3 of them are kept.
+	opt.ShardCount = 1
+	m = New(opt)
+	for i := 0; i < 100; i++ {
+		m.SetByHashTx(uint64(i), uint64(i), fmt.Sprintf("id-%d", i), []byte{byte(i)}, noTTL)
+	}
+	for i := 0; i < 100; i += 2 {
+		m.SetByHashTx(uint64(i), uint64(i), fmt.Sprintf("id-%d", i), []byte{byte(i), byte(i)}, noTTL)
+	}
+	m.Compact()
+	assert.Equal(m.GetStats().Unused, uint64(0))
+	for i := 0; i < 100; i++ {
+		val, _, ok := m.GetByHash(uint64(i), uint64(i))
+		assert.True(ok)
+		assert.Equal(val[0], byte(i))
+	}
+}
+
+func TestNoValueCopy(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.NoValueCopy = true
+	m := New(opt)
+
+	m.Set("foo", []byte("bar"))
+	v1, _, ok := m.Get("foo")
+	assert.True(ok)
+	v2, _, _ := m.Get("foo")
+	assert.Equal(v1, []byte("bar"))
+	assert.True(&v1[0] == &v2[0])
+
+	m = New(DefaultOptions)
+	m.Set("foo", []byte("bar"))
+	v1, _, _ = m.Get("foo")
+	v2, _, _ = m.Get("foo")
+	assert.False(&v1[0] == &v2[0])
+}
+
+func TestIndexMap(t *testing.T) {
+	assert := assert.New(t)
+	const num = 1000
+	opt := getOptions(num, 3)
+	opt.IndexKind = IndexMap
+	m := New(opt)
+
+	for i := 0; i < num; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+	checkValidData(assert, m, 0, num)
+
+	for i := 0; i < num/2; i++ {
+		k, _ := genKV(i)
+		m.Remove(k)
+	}
+	m.Migrate()
+	checkInvalidData(assert, m, 0, num/2)
+	checkValidData(assert, m, num/2, num)
+}
+
+func TestRemovePrefix(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.Set("tenant:1:"+k, v)
+		m.Set("tenant:2:"+k, v)
+	}
+	assert.Equal(m.RemovePrefix("tenant:1:"), 100)
+	assert.Equal(m.RemovePrefix("tenant:1:"), 0)
+
+	var count int
+	m.Scan(func(key, _ []byte, _ int64) bool {
+		assert.Equal(string(key[:9]), "tenant:2:")
+		count++
+		return true
+	})
+	assert.Equal(count, 100)
+	assert.Equal(m.GetStats().Unused, uint64(100*27))
+
+	// values are not decompressed.
+	opt := DefaultOptions
+	opt.Compressor = &countCompressor{}
+	m = New(opt)
+	large := bytes.Repeat([]byte("hello"), 100)
+	m.Set("foo:1", large)
+	m.Set("bar:1", large)
+	assert.Equal(m.RemovePrefix("foo:"), 1)
+	assert.Equal(opt.Compressor.(*countCompressor).decompressed, 0)
+}
+
+// countCompressor is a flateCompressor counting Decompress calls.
+type countCompressor struct {
+	flateCompressor
+	decompressed int
+}
+
+func (c *countCompressor) Decompress(src []byte) ([]byte, error) {
+	c.decompressed++
+	return c.flateCompressor.Decompress(src)
+}
+
+func TestScanDelete(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+	m.ScanDelete(func(key, value []byte, ttl int64) bool {
+		return key[len(key)-1]%2 == 0
+	})
+
+	for i := 0; i < 100; i++ {
+		k, _ := genKV(i)
+		_, _, ok := m.Get(k)
+		assert.Equal(ok, k[len(k)-1]%2 == 0)
+	}
+	assert.Equal(m.GetStats().Len, 50)
+}
+
+func TestSetIfGreater(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	assert.True(m.SetIfGreater("foo", 10, time.Hour))
+	assert.False(m.SetIfGreater("foo", 10, time.Hour))
+	assert.False(m.SetIfGreater("foo", 5, time.Hour))
+	assert.True(m.SetIfGreater("foo", 20, time.Hour))
+
+	val, _, ok := m.Get("foo")
+	assert.True(ok)
+	assert.Equal(string(val), "20")
+
+	// negative.
+	assert.True(m.SetIfGreater("neg", -10, time.Hour))
+	assert.True(m.SetIfGreater("neg", -5, time.Hour))
+
+	// expired key is writable.
+	m.SetEx("expired", []byte("100"), time.Millisecond)
+	time.Sleep(time.Millisecond * 10)
+	assert.True(m.SetIfGreater("expired", 1, time.Hour))
+
+	// not a number.
+	m.Set("str", []byte("bar"))
+	assert.False(m.SetIfGreater("str", 1, time.Hour))
+
+	// non-positive duration removes the key.
+	assert.False(m.SetIfGreater("foo", 30, 0))
+	_, _, ok = m.Get("foo")
+	assert.False(ok)
+}
+
+func TestMaxEntriesPerBucket(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 4
+	opt.EvictInterval = -1
+	opt.MaxEntriesPerBucket = 10
+	m := New(opt)
+
+	for i := 0; i < 1000; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+	for _, s := range m.GetBucketStats() {
+		assert.Equal(s.Len, 10)
+	}
+	assert.Equal(m.GetStats().Evictions, uint64(1000-40))
+	assert.Equal(m.GetStats().CapacityEvictions, uint64(1000-40))
+	assert.Equal(m.GetStats().ExpiredEvictions, uint64(0))
+
+	// keys expiring soonest are evicted first.
+	opt.ShardCount = 1
+	opt.MaxEntriesPerBucket = 2
+	opt.EvictionSamples = 2
+	m = New(opt)
+	m.Set("nottl", []byte("bar"))
+	m.SetEx("soon", []byte("bar"), time.Minute)
+	m.SetEx("later", []byte("bar"), time.Hour)
+
+	_, _, ok := m.Get("soon")
+	assert.False(ok)
+	_, _, ok = m.Get("nottl")
+	assert.True(ok)
+	_, _, ok = m.Get("later")
+	assert.True(ok)
+}
+
+func TestFullReject(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 1
+	opt.EvictInterval = -1
+	opt.MaxEntriesPerBucket = 2
+	opt.FullPolicy = FullReject
+	m := New(opt)
+
+	assert.True(m.Set("foo", []byte("bar")))
+	m.SetTx("expired", []byte("bar"), time.Now().Add(time.Millisecond*10).UnixNano())
+
+	// rejected without evicting alive keys.
+	assert.False(m.Set("new", []byte("bar")))
+	_, _, ok := m.Get("new")
+	assert.False(ok)
+	assert.Equal(m.GetStats().Rejections, uint64(1))
+	assert.Equal(m.GetStats().Evictions, uint64(0))
+
+	// rejection is told apart from update.
+	_, err := m.TrySetTx("new", []byte("bar"), noTTL)
+	assert.Equal(err, ErrRejected)
+	assert.False(m.Rename("foo", "new"))
+	_, _, ok = m.Get("foo")
+	assert.True(ok)
+
+	// existing keys can be updated.
+	newField, err := m.TrySetTx("foo", []byte("baz"), noTTL)
+	assert.False(newField)
+	assert.Nil(err)
+	val, _, _ := m.Get("foo")
+	assert.Equal(val, []byte("baz"))
+
+	// expired keys make room.
+	time.Sleep(20 * time.Millisecond)
+	assert.True(m.Set("new", []byte("bar")))
+	assert.Equal(m.GetStats().Len, 2)
+
+	opt.FullPolicy = FullReject + 1
+	assert.Panics(func() { New(opt) })
+}
+
+func TestGetSize(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	m.Set("hello", []byte("world"))
+	size, ok := m.GetSize("hello")
+	assert.True(ok)
+	assert.Equal(size, 12)
+
+	m.Set("hello", make([]byte, 200))
+	size, ok = m.GetSize("hello")
+	assert.True(ok)
+	assert.Equal(size, 2+1+5+200)
+
+	size, ok = m.GetSize("none")
+	assert.False(ok)
+	assert.Equal(size, 0)
+}
+
+func TestTopBySize(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	for i := 0; i < 100; i++ {
+		k, _ := genKV(i)
+		m.Set(k, make([]byte, i))
+	}
+
+	top := m.TopBySize(3)
+	assert.Equal(len(top), 3)
+	for i, ks := range top {
+		k, _ := genKV(99 - i)
+		size, _ := m.GetSize(k)
+		assert.Equal(ks.Key, k)
+		assert.Equal(ks.Size, size)
+	}
+
+	assert.Equal(len(m.TopBySize(1000)), 100)
+	assert.Equal(len(m.TopBySize(math.MaxInt)), 100)
+	assert.Nil(m.TopBySize(0))
+}
+
+func TestScanByExpiry(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	base := time.Now().Add(time.Hour).UnixNano()
+	for i := 0; i < 1000; i++ {
+		k, v := genKV(i)
+		m.SetTx(k, v, base+int64(1000-i))
+	}
+	m.Set("nottl", []byte("bar"))
+	m.SetTx("expired", []byte("bar"), time.Now().Add(-time.Second).UnixNano())
+
+	var keys []string
+	var last int64
+	m.ScanByExpiry(10, func(key, val []byte, ttl int64) bool {
+		assert.Equal(key, val)
+		assert.Greater(ttl, last)
+		last = ttl
+		keys = append(keys, string(key))
+		return true
+	})
+	assert.Equal(len(keys), 10)
+	k, _ := genKV(999)
+	assert.Equal(keys[0], k)
+	k, _ = genKV(990)
+	assert.Equal(keys[9], k)
+
+	// stop iteration.
+	var count int
+	m.ScanByExpiry(10, func(key, val []byte, ttl int64) bool {
+		count++
+		return false
+	})
+	assert.Equal(count, 1)
+
+	// limit exceeds keys with TTL.
+	count = 0
+	m.ScanByExpiry(2000, func(key, val []byte, ttl int64) bool {
+		count++
+		return true
+	})
+	assert.Equal(count, 1000)
+
+	// unbounded limit.
+	count = 0
+	m.ScanByExpiry(math.MaxInt, func(key, val []byte, ttl int64) bool {
+		count++
+		return true
+	})
+	assert.Equal(count, 1000)
+}
+
+func TestRecentKeys(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.TrackRecent = true
+	opt.TrackCreation = true
+	opt.PadValues = true
+	m := New(opt)
+
+	for i := 0; i < 1000; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+	k0, v0 := genKV(0)
+	m.Set(k0, v0)
+	m.SetTx("expired", []byte("bar"), time.Now().Add(-time.Second).UnixNano())
+	m.Migrate()
+
+	keys := m.RecentKeys(3)
+	k999, _ := genKV(999)
+	k998, _ := genKV(998)
+	assert.Equal(keys, []string{k0, k999, k998})
+	assert.Equal(len(m.RecentKeys(2000)), 1000)
+	assert.Equal(len(m.RecentKeys(math.MaxInt)), 1000)
+
+	val, _, _ := m.Get(k0)
+	assert.Equal(val, v0)
+
+	// disabled.
+	m = New(DefaultOptions)
+	m.Set("foo", []byte("bar"))
+	assert.Nil(m.RecentKeys(3))
+}
+
+func TestShardIndex(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 16
+	m := New(opt)
+
+	counts := make([]int, m.ShardCount())
+	for i := 0; i < 1000; i++ {
+		k, _ := genKV(i)
+		n := m.ShardIndex(k)
+		bucket, _ := m.getShard(k)
+		assert.Equal(n, bucket.id)
+		counts[n]++
+	}
+	for _, n := range counts {
+		assert.Greater(n, 0)
+	}
+}
+
+func TestByHash(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.VerifyKeys = true
+	m := New(opt)
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		h := hashFn(k)
+		m.SetByHash(h.Hi, h.Lo, k, v, time.Hour)
+
+		bucket, _ := m.getShard(k)
+		assert.Equal(m.ShardForHash(h.Hi, h.Lo), bucket.id)
+	}
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		h := hashFn(k)
+		val, ts, ok := m.GetByHash(h.Hi, h.Lo)
+		assert.True(ok)
+		assert.Equal(val, v)
+		assert.Greater(ts, time.Now().UnixNano())
+
+		// compatible with Get.
+		val, _, ok = m.Get(k)
+		assert.True(ok)
+		assert.Equal(val, v)
+	}
+	_, _, ok := m.GetByHash(0, 0)
+	assert.False(ok)
+
+	// no ttl.
+	h := hashFn("foo")
+	assert.True(m.SetByHashTx(h.Hi, h.Lo, "foo", []byte("bar"), noTTL))
+	val, ts, ok := m.GetByHash(h.Hi, h.Lo)
+	assert.True(ok)
+	assert.Equal(val, []byte("bar"))
+	assert.Equal(ts, int64(noTTL))
+
+	// non-positive duration removes the key.
+	assert.False(m.SetByHash(h.Hi, h.Lo, "foo", []byte("bar"), 0))
+	_, _, ok = m.Get("foo")
+	assert.False(ok)
+	alloc := m.GetStats().Alloc
+	assert.False(m.SetByHash(h.Hi, h.Lo, "foo", []byte("bar"), -time.Second))
+	assert.Equal(m.GetStats().Alloc, alloc)
+}
+
+func TestReshard(t *testing.T) {
+	assert := assert.New(t)
+	const num = 1000
+	opt := DefaultOptions
+	opt.ShardCount = 4
+	opt.TrackCreation = true
+	opt.Reshardable = true
+	m := New(opt)
+
+	for i := 0; i < num; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+	m.SetEx("expired", []byte("bar"), time.Millisecond)
+	time.Sleep(time.Millisecond * 10)
+
+	assert.Nil(m.Reshard(64))
+	assert.Equal(m.ShardCount(), 64)
+	checkValidData(assert, m, 0, num)
+	assert.Equal(m.GetStats().Len, num)
+
+	for _, s := range m.GetBucketStats() {
+		assert.Greater(s.Len, 0)
+	}
+	_, _, ok := m.GetWithAge("00000001")
+	assert.True(ok)
+
+	assert.Nil(m.Reshard(1))
+	checkValidData(assert, m, 0, num)
+
+	assert.NotNil(m.Reshard(100))
+	assert.Equal(m.ShardCount(), 1)
+
+	assert.EqualError(New(DefaultOptions).Reshard(64), "cache: Reshard requires Reshardable option")
+}
+
+func TestReshardConcurrent(t *testing.T) {
+	assert := assert.New(t)
+	const num = 1000
+	opt := DefaultOptions
+	opt.ShardCount = 4
+	opt.ActiveExpiryInterval = time.Millisecond
+	opt.Reshardable = true
+	m := New(opt)
+	defer m.Close()
+
+	// writes during Reshard are not lost.
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < num; i += 4 {
+				k, v := genKV(i)
+				m.Set(k, v)
+				m.Get(k)
+			}
+		}(w)
+	}
+	for _, n := range []uint32{16, 2, 64, 8} {
+		assert.Nil(m.Reshard(n))
+	}
+	wg.Wait()
+
+	assert.Equal(m.ShardCount(), 8)
+	checkValidData(assert, m, 0, num)
+}
+
+func TestMigrateBucket(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 4
+	opt.EvictInterval = -1
+	m := New(opt)
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+	// fragment only one bucket.
+	var fragmented int
+	for i := 0; i < 100; i++ {
+		k, _ := genKV(i)
+		if bucket, _ := m.getShard(k); bucket.id == 1 {
+			m.Remove(k)
+			fragmented++
+		}
+	}
+	assert.Greater(fragmented, 0)
+
+	assert.Equal(m.MigrateFragmented(), 1)
+	assert.Equal(m.MigrateFragmented(), 0)
+	stats := m.GetBucketStats()
+	assert.Equal(stats[1].Migrates, uint64(1))
+	assert.Equal(stats[1].Unused, uint64(0))
+
+	assert.Nil(m.MigrateBucket(0))
+	assert.Equal(m.GetBucketStats()[0].Migrates, uint64(1))
+	assert.NotNil(m.MigrateBucket(-1))
+	assert.NotNil(m.MigrateBucket(4))
+}
+
+func TestEvictExpiredKeysRoundRobin(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 8
+	opt.EvictInterval = -1
+	m := New(opt)
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.SetEx(k, v, time.Millisecond)
+	}
+	time.Sleep(time.Millisecond * 10)
+
+	// every bucket is swept once.
+	for i := 0; i < 8; i++ {
+		m.EvictExpiredKeys()
+	}
+	for _, s := range m.GetBucketStats() {
+		assert.Greater(s.Probes, uint64(0))
+	}
+}
+
+func TestScanContext(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 1
+	m := New(opt)
+
+	for i := 0; i < 5000; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+
+	var count int
+	err := m.ScanContext(context.Background(), func(key, val []byte, ttl int64) bool {
+		count++
+		return true
+	})
+	assert.Nil(err)
+	assert.Equal(count, 5000)
+
+	// cancel within a bucket.
+	ctx, cancel := context.WithCancel(context.Background())
+	count = 0
+	err = m.ScanContext(ctx, func(key, val []byte, ttl int64) bool {
+		if count++; count == 10 {
+			cancel()
+		}
+		return true
+	})
+	assert.ErrorIs(err, context.Canceled)
+	assert.Less(count, 5000)
+
+	// canceled before scan.
+	count = 0
+	err = m.ScanContext(ctx, func(key, val []byte, ttl int64) bool {
+		count++
+		return true
+	})
+	assert.ErrorIs(err, context.Canceled)
+	assert.Equal(count, 0)
+}
+
+func TestGetOrDefault(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+	m.Set("foo", []byte("bar"))
+	m.SetTx("expired", []byte("bar"), time.Now().Add(-time.Second).UnixNano())
+
+	val := m.GetOrDefault("foo", []byte("def"))
+	assert.Equal(val, []byte("bar"))
+	val[0] = 'x'
+	assert.Equal(m.GetOrDefault("foo", nil), []byte("bar"))
+
+	assert.Equal(m.GetOrDefault("none", []byte("def")), []byte("def"))
+	assert.Equal(m.GetOrDefault("expired", []byte("def")), []byte("def"))
+	assert.Nil(m.GetOrDefault("none", nil))
+}
+
+func TestGetEx(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	m.Set("foo", []byte("bar"))
+	m.SetEx("ttl", []byte("bar"), time.Minute)
+
+	val, expireAt, hasTTL, ok := m.GetEx("foo")
+	assert.True(ok)
+	assert.False(hasTTL)
+	assert.True(expireAt.IsZero())
+	assert.Equal(val, []byte("bar"))
+
+	val, expireAt, hasTTL, ok = m.GetEx("ttl")
+	assert.True(ok)
+	assert.True(hasTTL)
+	assert.WithinDuration(expireAt, time.Now().Add(time.Minute), time.Second)
+	assert.Equal(val, []byte("bar"))
+
+	val, _, hasTTL, ok = m.GetEx("none")
+	assert.False(ok)
+	assert.False(hasTTL)
+	assert.Nil(val)
+}
+
+func TestSetVersioned(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.Versioned = true
+	opt.TrackRecent = true
+	opt.PadValues = true
+	m := New(opt)
+
+	assert.True(m.SetVersioned("foo", []byte("v2"), 2, time.Minute))
+	val, version, ok := m.GetVersioned("foo")
+	assert.True(ok)
+	assert.Equal(val, []byte("v2"))
+	assert.Equal(version, uint64(2))
+
+	// stale or same versions are rejected.
+	assert.False(m.SetVersioned("foo", []byte("v1"), 1, time.Minute))
+	assert.False(m.SetVersioned("foo", []byte("v2-dup"), 2, time.Minute))
+	val, version, _ = m.GetVersioned("foo")
+	assert.Equal(val, []byte("v2"))
+	assert.Equal(version, uint64(2))
+
+	// newer version in place and reallocated.
+	assert.True(m.SetVersioned("foo", []byte("v3"), 3, 0))
+	assert.True(m.SetVersioned("foo", []byte("version-4-longer-value"), 4, 0))
+	val, version, _ = m.GetVersioned("foo")
+	assert.Equal(val, []byte("version-4-longer-value"))
+	assert.Equal(version, uint64(4))
+	m.Migrate()
+	_, version, _ = m.GetVersioned("foo")
+	assert.Equal(version, uint64(4))
+
+	// Set resets the version.
+	m.Set("foo", []byte("plain"))
+	_, version, _ = m.GetVersioned("foo")
+	assert.Equal(version, uint64(0))
+
+	// expired keys do not block any version.
+	m.SetTx("expired", []byte("bar"), time.Now().Add(-time.Second).UnixNano())
+	assert.True(m.SetVersioned("expired", []byte("bar"), 0, 0))
+
+	_, _, ok = m.GetVersioned("none")
+	assert.False(ok)
+
+	// disabled.
+	m = New(DefaultOptions)
+	m.Set("foo", []byte("bar"))
+	_, version, ok = m.GetVersioned("foo")
+	assert.True(ok)
+	assert.Equal(version, uint64(0))
+	assert.Panics(func() {
+		m.SetVersioned("foo", []byte("bar"), 1, 0)
+	})
+}
+
+func TestScanChunked(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 4
+	m := New(opt)
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+	m.SetTx("expired", []byte("expired"), time.Now().Add(-time.Second).UnixNano())
+
+	for _, chunk := range []int{0, 1, 7, 1000} {
+		var count int
+		m.ScanChunked(chunk, func(key, val []byte, ttl int64) bool {
+			assert.Equal(key, val)
+			assert.Equal(ttl, int64(noTTL))
+			count++
+			return true
+		})
+		assert.Equal(count, 100)
+	}
+
+	// writes in callback do not deadlock, and removed keys are skipped.
+	var count int
+	m.ScanChunked(10, func(key, val []byte, ttl int64) bool {
+		if count == 0 {
+			for i := 0; i < 100; i++ {
+				k, _ := genKV(i)
+				m.Remove(k)
+			}
+		}
+		count++
+		return true
+	})
+	assert.LessOrEqual(count, 10)
+	assert.Equal(m.GetStats().Len, 0)
+
+	// stop early.
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+	count = 0
+	m.ScanChunked(10, func(key, val []byte, ttl int64) bool {
+		count++
+		return count < 15
+	})
+	assert.Equal(count, 15)
+
+	// keys relocated by updates are not visited again, even if the buckets need migration.
+	seen := map[string]int{}
+	var visits int
+	m.ScanChunked(10, func(key, val []byte, ttl int64) bool {
+		seen[string(key)]++
+		visits++
+		if visits%2 == 0 {
+			m.Set(string(key), append(val, "-longer"...))
+		} else {
+			m.Set(string(key), val[:len(val)/2])
+		}
+		return visits < 1000
+	})
+	assert.Equal(visits, 100)
+	for _, n := range seen {
+		assert.Equal(n, 1)
+	}
+	for _, b := range m.buckets {
+		assert.Equal(b.scanners.Load(), int32(0))
+	}
+
+	// keys alive during the scan are visited at least once, even if buckets are migrated.
+	visited := map[string]bool{}
+	m.ScanChunked(10, func(key, val []byte, ttl int64) bool {
+		if len(visited) == 0 {
+			m.Migrate()
+		}
+		visited[string(key)] = true
+		return true
+	})
+	for i := 0; i < 100; i++ {
+		k, _ := genKV(i)
+		assert.True(visited[k])
+	}
+
+	// keys stored by hashes other than xxh3 of them are visited.
+	m = New(opt)
+	for i := 0; i < 20; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+		m.SetByHashTx(uint64(i), uint64(i), "id-"+k, v, noTTL)
+	}
+	var byHash int
+	count = 0
+	m.ScanChunked(4, func(key, val []byte, ttl int64) bool {
+		if string(key[:3]) == "id-" {
+			assert.Equal(string(key[3:]), string(val))
+			byHash++
+		}
+		count++
+		return true
+	})
+	assert.Equal(byHash, 20)
+	assert.Equal(count, 40)
+}
+
+func TestScanCollect(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+	m.SetTx("expired", []byte("expired"), time.Now().Add(-time.Second).UnixNano())
+
+	keys := ScanCollect(m, func(key, _ []byte, _ int64) (string, bool) {
+		return string(key), key[len(key)-1] == '0'
+	})
+	slices.Sort(keys)
+	assert.Equal(len(keys), 7)
+	for _, k := range keys {
+		assert.Equal(k[len(k)-1], byte('0'))
+	}
+
+	sizes := ScanCollect(m, func(_, value []byte, _ int64) (int, bool) {
+		return len(value), true
+	})
+	assert.Equal(len(sizes), 100)
+	assert.Nil(ScanCollect(New(DefaultOptions), func(_, _ []byte, _ int64) (int, bool) {
+		return 0, true
+	}))
+}
+
+func TestSetImmutable(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 1
+	m := New(opt)
+	assert.Panics(func() {
+		m.SetImmutable("foo", []byte("bar"))
+	})
+
+	opt.Immutable = true
+	m = New(opt)
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		if i%2 == 0 {
+			assert.True(m.SetImmutable(k, v))
+		} else {
+			m.Set(k, v)
+		}
+	}
+	m.Set("foo", []byte("bar"))
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		val, ok := m.GetImmutable(k)
+		assert.Equal(ok, i%2 == 0)
+		if ok {
+			assert.Equal(val, v)
+		}
+		// pinned entries have no ttl.
+		_, ttl, ok := m.Get(k)
+		assert.True(ok)
+		assert.Equal(ttl, int64(noTTL))
+	}
+	_, ok := m.GetImmutable("none")
+	assert.False(ok)
+
+	// writes to pinned keys are rejected.
+	k, v := genKV(0)
+	assert.False(m.SetImmutable(k, []byte("new")))
+	assert.False(m.Set(k, []byte("new")))
+	_, err := m.TrySetTx(k, []byte("new"), noTTL)
+	assert.ErrorIs(err, ErrRejected)
+	assert.False(m.SetTTL(k, time.Now().Add(time.Hour).UnixNano()))
+	val, ok := m.GetImmutable(k)
+	assert.True(ok)
+	assert.Equal(val, v)
+
+	// removed pinned keys are writable again.
+	assert.True(m.Remove(k))
+	assert.True(m.Set(k, v))
+	_, ok = m.GetImmutable(k)
+	assert.False(ok)
+
+	// pinned entries are moved together in their original order.
+	m.Remove("foo")
+	m.Migrate()
+	b := m.buckets[0]
+	var pinned []Idx
+	b.index.All(func(_ Key, idx Idx) bool {
+		if b.isPinned(idx) {
+			pinned = append(pinned, idx)
+		}
+		return true
+	})
+	slices.SortFunc(pinned, func(a, b Idx) int { return a.start() - b.start() })
+	assert.Equal(len(pinned), 49)
+	for i, idx := range pinned {
+		_, key, _ := b.findEntry(idx)
+		k, _ := genKV(2 * (i + 1))
+		assert.Equal(string(key), k)
+	}
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		val, _, ok := m.Get(k)
+		assert.True(ok)
+		assert.Equal(val, v)
+	}
+}
+
+func TestSetMiss(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.NegativeCache = true
+	m := New(opt)
+
+	m.Set("foo", []byte("bar"))
+	m.SetMiss("miss", time.Minute)
+
+	val, _, found, isMiss := m.GetOrMiss("foo")
+	assert.True(found)
+	assert.False(isMiss)
+	assert.Equal(val, []byte("bar"))
+
+	val, ttl, found, isMiss := m.GetOrMiss("miss")
+	assert.True(found)
+	assert.True(isMiss)
+	assert.Nil(val)
+	assert.Greater(ttl, time.Now().UnixNano())
+
+	_, _, found, isMiss = m.GetOrMiss("none")
+	assert.False(found)
+	assert.False(isMiss)
+
+	// tombstones are hidden from Get and Scan.
+	_, _, ok := m.Get("miss")
+	assert.False(ok)
+	m.Scan(func(key, val []byte, ttl int64) bool {
+		assert.Equal(string(key), "foo")
+		return true
+	})
+
+	// tombstones are hidden from other paths too.
+	it := m.Iterator()
+	var keys []string
+	for key, _, _, ok := it.Next(); ok; key, _, _, ok = it.Next() {
+		keys = append(keys, string(key))
+	}
+	assert.Equal(keys, []string{"foo"})
+	assert.Equal(m.LiveLen(), 1)
+	top := m.TopBySize(10)
+	assert.Equal(len(top), 1)
+	assert.Equal(top[0].Key, "foo")
+	m.ScanDelete(func(key, _ []byte, _ int64) bool {
+		assert.Equal(string(key), "foo")
+		return true
+	})
+	assert.Equal(m.RemovePrefix("mi"), 0)
+	_, _, found, isMiss = m.GetOrMiss("miss")
+	assert.True(found)
+	assert.True(isMiss)
+
+	m.SetMiss("exp", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	m.ScanExpired(func(key []byte, _ int64) bool {
+		assert.Fail("tombstone scanned", string(key))
+		return true
+	})
+
+	// removing tombstones does not report alive keys.
+	m.SetMiss("rm1", time.Minute)
+	m.SetMiss("rm2", time.Minute)
+	assert.False(m.Remove("rm1"))
+	assert.Equal(m.MRemove([]string{"rm2"}), 0)
+	_, _, found, _ = m.GetOrMiss("rm1")
+	assert.False(found)
+
+	// overwritten by Set.
+	m.Set("miss", []byte("hit"))
+	val, _, found, isMiss = m.GetOrMiss("miss")
+	assert.True(found)
+	assert.False(isMiss)
+	assert.Equal(val, []byte("hit"))
+
+	// non-positive duration removes the key.
+	m.SetMiss("foo", -time.Second)
+	_, _, found, _ = m.GetOrMiss("foo")
+	assert.False(found)
+	m.Set("foo", []byte("hit"))
+	m.SetMiss("foo", 0)
+	_, _, found, _ = m.GetOrMiss("foo")
+	assert.False(found)
+
+	assert.Panics(func() {
+		New(DefaultOptions).SetMiss("foo", time.Minute)
+	})
+}
+
+func TestScanExpired(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.EvictInterval = -1
+	m := New(opt)
+
+	m.Set("foo", []byte("bar"))
+	m.SetEx("alive", []byte("bar"), time.Minute)
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.SetTx(k, v, time.Now().Add(-time.Second).UnixNano())
+	}
+
+	var count int
+	m.ScanExpired(func(key []byte, expiredAt int64) bool {
+		assert.NotEqual(string(key), "foo")
+		assert.NotEqual(string(key), "alive")
+		assert.Less(expiredAt, time.Now().UnixNano())
+		count++
+		return true
+	})
+	assert.Equal(count, 100)
+
+	// stop iteration.
+	count = 0
+	m.ScanExpired(func(key []byte, expiredAt int64) bool {
+		count++
+		return false
+	})
+	assert.Equal(count, 1)
+
+	// nothing left after eviction.
+	m.DeleteExpired()
+	m.ScanExpired(func(key []byte, expiredAt int64) bool {
+		assert.Fail("unexpected expired key")
+		return true
+	})
+}
+
+func TestEvictChannel(t *testing.T) {
+	assert := assert.New(t)
+	ch := make(chan EvictedEntry, 10)
+	opt := DefaultOptions
+	opt.ShardCount = 1
+	opt.EvictInterval = -1
+	opt.EvictChannel = ch
+	opt.Reshardable = true
+	m := New(opt)
+
+	m.SetTx("foo", []byte("bar"), time.Now().Add(-time.Second).UnixNano())
+	m.Set("alive", []byte("bar"))
+	assert.Equal(m.DeleteExpired(), 1)
+	assert.Equal(m.GetStats().ExpiredEvictions, uint64(1))
+	assert.Equal(m.GetStats().CapacityEvictions, uint64(0))
+
+	entry := <-ch
+	assert.Equal(entry.Key, "foo")
+	assert.Equal(entry.Value, []byte("bar"))
+	assert.Less(entry.TTL, time.Now().UnixNano())
+
+	// removed keys are not evicted.
+	m.Remove("alive")
+	assert.Equal(len(ch), 0)
+
+	// notifications are dropped if the channel is full.
+	for i := 0; i < 20; i++ {
+		k, v := genKV(i)
+		m.SetTx(k, v, time.Now().Add(-time.Second).UnixNano())
+	}
+	assert.Equal(m.DeleteExpired(), 20)
+	assert.Equal(len(ch), 10)
+	assert.Equal(m.GetStats().DroppedEvictions, uint64(10))
+
+	// expired entries dropped by migrate, compact and Reshard are evicted.
+	for len(ch) > 0 {
+		<-ch
+	}
+	for _, fn := range []func(){m.Migrate, m.Compact, func() { m.Reshard(2) }} {
+		m.ResetStats()
+		m.SetTx("exp", []byte("exp"), time.Now().Add(-time.Second).UnixNano())
+		fn()
+		assert.Equal(m.GetStats().ExpiredEvictions, uint64(1))
+		assert.Equal(len(ch), 1)
+		entry := <-ch
+		assert.Equal(entry.Key, "exp")
+		assert.Equal(entry.Value, []byte("exp"))
+	}
+
+	// tombstones are not sent.
+	opt.NegativeCache = true
+	m = New(opt)
+	for _, fn := range []func(){func() { m.DeleteExpired() }, m.Migrate, m.Compact, func() { m.Reshard(2) }} {
+		m.SetMiss("miss", time.Millisecond)
+		time.Sleep(2 * time.Millisecond)
+		fn()
+		assert.Equal(len(ch), 0)
+	}
+}
+
+func TestSetExNonPositive(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	m.Set("foo", []byte("bar"))
+	assert.False(m.SetEx("foo", []byte("bar"), 0))
+	_, _, ok := m.Get("foo")
+	assert.False(ok)
+
+	// no dead entry is stored.
+	alloc := m.GetStats().Alloc
+	assert.False(m.SetEx("bar", []byte("bar"), -time.Second))
+	assert.Equal(m.GetStats().Len, 0)
+	assert.Equal(m.GetStats().Alloc, alloc)
+}
+
+func TestSetExReturnOld(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	ttl, ok := m.SetExReturnOld("foo", []byte("bar"), time.Minute)
+	assert.False(ok)
+	assert.Equal(ttl, int64(0))
+
+	_, old, _ := m.Get("foo")
+	ttl, ok = m.SetExReturnOld("foo", []byte("baz"), time.Hour)
+	assert.True(ok)
+	assert.Equal(ttl, old)
+
+	val, newTTL, ok := m.Get("foo")
+	assert.True(ok)
+	assert.Equal(val, []byte("baz"))
+	assert.Greater(newTTL, old)
+
+	// no ttl.
+	m.Set("bar", []byte("bar"))
+	ttl, ok = m.SetExReturnOld("bar", []byte("bar"), time.Minute)
+	assert.True(ok)
+	assert.Equal(ttl, int64(noTTL))
+
+	// non-positive duration removes the key.
+	ttl, ok = m.SetExReturnOld("foo", nil, 0)
+	assert.True(ok)
+	assert.Equal(ttl, newTTL)
+	_, _, ok = m.Get("foo")
+	assert.False(ok)
+
+	// expired keys do not exist.
+	m.SetTx("exp", []byte("exp"), time.Now().Add(-time.Second).UnixNano())
+	_, ok = m.SetExReturnOld("exp", []byte("exp"), time.Minute)
+	assert.False(ok)
+}
+
+func TestGetLease(t *testing.T) {
+	assert := assert.New(t)
+	var allocs int
+	opt := DefaultOptions
+	opt.BufferPool = &sync.Pool{New: func() any {
+		allocs++
+		buf := make([]byte, 0, 64)
+		return &buf
+	}}
+	m := New(opt)
+	m.Set("foo", []byte("bar"))
+
+	val, release, ok := m.GetLease("foo")
+	assert.True(ok)
+	assert.Equal(val, []byte("bar"))
+	assert.Equal(cap(val), 64)
+	release()
+
+	_, release, ok = m.GetLease("none")
+	assert.False(ok)
+	release()
+
+	// without pool.
+	m = New(DefaultOptions)
+	m.Set("foo", []byte("bar"))
+	val, release, ok = m.GetLease("foo")
+	assert.True(ok)
+	assert.Equal(val, []byte("bar"))
+	release()
+	assert.Equal(allocs, 1)
+
+	// pool without New.
+	opt.BufferPool = &sync.Pool{}
+	m = New(opt)
+	m.Set("foo", []byte("bar"))
+	val, release, ok = m.GetLease("foo")
+	assert.True(ok)
+	assert.Equal(val, []byte("bar"))
+	release()
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	for _, pad := range []bool{false, true} {
+		assert := assert.New(t)
+		opt := DefaultOptions
+		opt.ShardCount = 1
+		opt.VerifyChecksum = true
+		opt.Versioned = true
+		opt.TrackRecent = true
+		opt.PadValues = pad
+		evicted := make(chan EvictedEntry, 10)
+		opt.EvictChannel = evicted
+		m := New(opt)
+
+		m.Set("foo", []byte("bar"))
+		m.Set("abc", []byte("123"))
+		// updated in-place.
+		m.Set("foo", []byte("baz"))
+		m.SetVersioned("ver", []byte("ver"), 1, 0)
+
+		for _, k := range []string{"foo", "abc", "ver"} {
+			_, _, ok := m.Get(k)
+			assert.True(ok)
+		}
+		assert.Equal(m.GetStats().ChecksumFailures, uint64(0))
+
+		// corrupt the value of foo.
+		b := m.buckets[0]
+		idx, _ := b.index.Get(hashFn("foo"))
+		_, _, val := b.findEntry(idx)
+		val[0] ^= 1
+
+		_, _, ok := m.Get("foo")
+		assert.False(ok)
+		val, _, ok = m.Get("abc")
+		assert.True(ok)
+		assert.Equal(val, []byte("123"))
+
+		var count int
+		m.Scan(func(key, _ []byte, _ int64) bool {
+			assert.NotEqual(string(key), "foo")
+			count++
+			return true
+		})
+		assert.Equal(count, 2)
+		assert.Equal(m.GetStats().ChecksumFailures, uint64(2))
+
+		// corrupted entries are not exposed by other paths.
+		for _, ks := range m.TopBySize(10) {
+			assert.NotEqual(ks.Key, "foo")
+		}
+		assert.NotContains(m.RecentKeys(10), "foo")
+		m.ScanDelete(func(key, _ []byte, _ int64) bool {
+			assert.NotEqual(string(key), "foo")
+			return true
+		})
+		assert.Equal(m.RemovePrefix("f"), 0)
+		m.SetTTL("foo", time.Now().Add(-time.Second).UnixNano())
+		m.ScanExpired(func(key []byte, _ int64) bool {
+			assert.NotEqual(string(key), "foo")
+			return true
+		})
+		m.EvictExpiredKeys()
+		assert.Equal(m.GetStats().Evictions, uint64(1))
+		assert.Equal(len(evicted), 0)
+
+		// survives migration.
+		m.Migrate()
+		_, _, ok = m.Get("foo")
+		assert.False(ok)
+		_, _, ok = m.Get("abc")
+		assert.True(ok)
+
+		// overwriting fixes it.
+		m.Set("foo", []byte("foo"))
+		val, _, ok = m.Get("foo")
+		assert.True(ok)
+		assert.Equal(val, []byte("foo"))
+
+		m.ResetStats()
+		assert.Equal(m.GetStats().ChecksumFailures, uint64(0))
+	}
+}
+
+func TestVerifyChecksumHeader(t *testing.T) {
+	for _, pad := range []bool{false, true} {
+		assert := assert.New(t)
+		opt := DefaultOptions
+		opt.ShardCount = 1
+		opt.VerifyChecksum = true
+		opt.Versioned = true
+		opt.NegativeCache = true // entries have flags.
+		opt.PadValues = pad
+		m := New(opt)
+
+		keys := []string{"key", "val", "flag", "ver", "last"}
+		for _, k := range keys {
+			m.Set(k, []byte(k))
+		}
+		b := m.buckets[0]
+		header := func(k string) (Idx, int) {
+			idx, _ := b.index.Get(hashFn(k))
+			return idx, b.headerPos(idx)
+		}
+
+		// corrupt the key length to overflow data.
+		idx, _ := header("key")
+		b.data[idx.start()] = 0xff
+		// corrupt the value length.
+		idx, pos := header("val")
+		b.data[pos-1] = 0x7f
+		// corrupt the flags.
+		_, pos = header("flag")
+		b.data[pos] ^= flagMiss
+		// corrupt the version.
+		idx, _ = header("ver")
+		b.data[b.versionPos(idx)] ^= 1
+		// corrupt the length of the last entry to read past data.
+		idx, _ = header("last")
+		b.data[idx.start()] |= 0x80
+
+		for _, k := range keys {
+			_, _, ok := m.Get(k)
+			assert.False(ok, k)
+		}
+		assert.Equal(m.GetStats().ChecksumFailures, uint64(len(keys)))
+
+		var count int
+		m.Scan(func([]byte, []byte, int64) bool {
+			count++
+			return true
+		})
+		m.ScanChunked(2, func([]byte, []byte, int64) bool {
+			count++
+			return true
+		})
+		assert.Equal(count, 0)
+
+		// overwriting fixes it, and counts the corrupted entries as unused.
+		m.Migrate()
+		assert.Equal(m.GetStats().Unused, uint64(0))
+		for _, k := range keys {
+			m.Set(k, []byte(k))
+			val, _, ok := m.Get(k)
+			assert.True(ok)
+			assert.Equal(val, []byte(k))
+		}
+		stats := m.GetStats()
+		assert.Greater(stats.Unused, uint64(0))
+		assert.LessOrEqual(stats.Unused, stats.Alloc)
+	}
+}
+
+func TestPadValues(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 1
+	opt.PadValues = true
+	opt.TrackCreation = true
+	m := New(opt)
+
+	assert.Equal(sizeClass(0), 8)
+	assert.Equal(sizeClass(8), 8)
+	assert.Equal(sizeClass(9), 16)
+	assert.Equal(sizeClass(1000), 1024)
+
+	m.Set("foo", []byte("12345"))
+	alloc := m.GetStats().Alloc
+	assert.Equal(m.GetStats().Padding, uint64(3))
+
+	// grow in place.
+	assert.False(m.Set("foo", []byte("1234567")))
+	val, _, ok := m.Get("foo")
+	assert.True(ok)
+	assert.Equal(val, []byte("1234567"))
+	stats := m.GetStats()
+	assert.Equal(stats.Alloc, alloc)
+	assert.Equal(stats.Unused, uint64(0))
+	assert.Equal(stats.Padding, uint64(1))
+
+	// shrink in place.
+	m.Set("foo", []byte("1"))
+	val, _, _ = m.Get("foo")
+	assert.Equal(val, []byte("1"))
+	assert.Equal(m.GetStats().Padding, uint64(7))
+
+	// outgrow the size class.
+	m.Set("foo", []byte("123456789"))
+	val, _, _ = m.Get("foo")
+	assert.Equal(val, []byte("123456789"))
+	stats = m.GetStats()
+	assert.Equal(stats.Unused, alloc)
+	assert.Equal(stats.Padding, uint64(7))
+
+	m.Set("bar", []byte("bar"))
+	m.Migrate()
+	val, _, _ = m.Get("foo")
+	assert.Equal(val, []byte("123456789"))
+	val, _, _ = m.Get("bar")
+	assert.Equal(val, []byte("bar"))
+	assert.Equal(m.GetStats().Padding, uint64(12))
+
+	m.Remove("foo")
+	m.Remove("bar")
+	assert.Equal(m.GetStats().Padding, uint64(0))
+}
+
+func TestSetAt(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	deadline := time.Now().Add(time.Minute)
+	assert.True(m.SetAt("foo", []byte("bar"), deadline))
+	_, ttl, ok := m.Get("foo")
+	assert.True(ok)
+	assert.Equal(ttl, deadline.UnixNano())
+
+	assert.True(m.SetAt("none", []byte("bar"), time.Time{}))
+	_, ttl, ok = m.Get("none")
+	assert.True(ok)
+	assert.Equal(ttl, int64(noTTL))
+
+	// a past deadline removes the key, no dead entry is stored.
+	assert.True(m.SetAt("expired", []byte("bar"), deadline))
+	alloc := m.GetStats().Alloc
+	assert.False(m.SetAt("expired", []byte("bar"), time.Now().Add(-time.Second)))
+	_, _, state := m.GetStale("expired")
+	assert.Equal(state, Absent)
+	assert.Equal(m.GetStats().Len, 2)
+	assert.False(m.SetAt("dead", []byte("bar"), time.Now().Add(-time.Second)))
+	assert.Equal(m.GetStats().Len, 2)
+	assert.Equal(m.GetStats().Alloc, alloc)
+}
+
+func TestGetStale(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.EvictInterval = -1
+	m := New(opt)
+
+	m.Set("fresh", []byte("bar"))
+	ts := time.Now().Add(-time.Second).UnixNano()
+	m.SetTx("stale", []byte("old"), ts)
+
+	val, _, state := m.GetStale("fresh")
+	assert.Equal(state, Fresh)
+	assert.Equal(val, []byte("bar"))
+
+	val, expireAt, state := m.GetStale("stale")
+	assert.Equal(state, Stale)
+	assert.Equal(val, []byte("old"))
+	assert.Equal(expireAt, ts)
+
+	// not evicted by read.
+	_, _, state = m.GetStale("stale")
+	assert.Equal(state, Stale)
+
+	val, _, state = m.GetStale("none")
+	assert.Equal(state, Absent)
+	assert.Nil(val)
+
+	m.DeleteExpired()
+	_, _, state = m.GetStale("stale")
+	assert.Equal(state, Absent)
+}
+
+func TestEvictCoverage(t *testing.T) {
+	assert := assert.New(t)
+	for _, kind := range []IndexKind{IndexSwiss, IndexMap} {
+		opt := DefaultOptions
+		opt.ShardCount = 1
+		opt.EvictInterval = -1
+		opt.EvictProbeStride = 8
+		opt.IndexKind = kind
+		m := New(opt)
+
+		ts := time.Now().Add(-time.Second).UnixNano()
+		for i := 0; i < 1000; i++ {
+			k, v := genKV(i)
+			if i%10 == 0 {
+				m.SetTx(k, v, ts)
+			} else {
+				m.Set(k, v)
+			}
+		}
+
+		// each pass stops early at live keys, expired keys are reclaimed over passes.
+		for i := 0; i < 5000 && m.GetStats().Len > 900; i++ {
+			m.EvictExpiredKeys()
+		}
+		assert.Equal(m.GetStats().Len, 900)
+
+		// samples of a few keys also cover the index over passes.
+		for i := 0; i < 1000; i += 10 {
+			k, v := genKV(i)
+			m.SetTx(k, v, ts)
+		}
+		b := m.buckets[0]
+		for i := 0; i < 50000 && m.GetStats().Len > 900; i++ {
+			b.sampleExpired(5)
+		}
+		assert.Equal(m.GetStats().Len, 900)
+	}
 }
@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrComputePanicked is returned by GetOrCompute to the callers waiting for a compute
+// that panicked, the panic itself propagates in the caller running compute.
+var ErrComputePanicked = errors.New("cache: compute panicked")
+
+// call is an in-flight compute of GetOrCompute.
+type call struct {
+	wg  sync.WaitGroup
+	val []byte
+	err error
+}
+
+// GetOrCompute returns the value for a given key, or on a miss calls compute and stores
+// its result with the expiration duration, no expiration if duration is not positive.
+// Concurrent callers missing the same key wait for a single compute and get a copy of its
+// result. The result is not stored if compute returns an error.
+func (c *GigaCache) GetOrCompute(keyStr string, duration time.Duration, compute func() ([]byte, error)) ([]byte, error) {
+	if val, _, ok := c.Get(keyStr); ok {
+		return val, nil
+	}
+	key := hashFn(keyStr)
+
+	c.callsMu.Lock()
+	if cl, ok := c.calls[key]; ok {
+		c.callsMu.Unlock()
+		cl.wg.Wait()
+		return slices.Clone(cl.val), cl.err
+	}
+	// check again as a compute may have finished since Get.
+	if val, _, ok := c.Get(keyStr); ok {
+		c.callsMu.Unlock()
+		return val, nil
+	}
+	cl := new(call)
+	cl.wg.Add(1)
+	if c.calls == nil {
+		c.calls = make(map[Key]*call)
+	}
+	c.calls[key] = cl
+	c.callsMu.Unlock()
+
+	completed := false
+	defer func() {
+		if !completed {
+			cl.val, cl.err = nil, ErrComputePanicked
+		}
+		c.callsMu.Lock()
+		delete(c.calls, key)
+		c.callsMu.Unlock()
+		cl.wg.Done()
+	}()
+
+	val, err := compute()
+	completed = true
+	// waiters share a copy, so that the caller may modify val once returned.
+	cl.val, cl.err = slices.Clone(val), err
+	if err == nil {
+		if duration > 0 {
+			c.SetEx(keyStr, val, duration)
+		} else {
+			c.Set(keyStr, val)
+		}
+	}
+	return val, err
+}
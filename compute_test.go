@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetOrCompute(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	var computes atomic.Int32
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var results [][]byte
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			val, err := m.GetOrCompute("foo", time.Minute, func() ([]byte, error) {
+				computes.Add(1)
+				time.Sleep(10 * time.Millisecond)
+				return []byte("bar"), nil
+			})
+			assert.Nil(err)
+			assert.Equal(val, []byte("bar"))
+			mu.Lock()
+			results = append(results, val)
+			mu.Unlock()
+		}()
+	}
+	close(start)
+	wg.Wait()
+	assert.Equal(computes.Load(), int32(1))
+
+	// each caller gets its own copy.
+	results[0][0] = 'x'
+	for _, val := range results[1:] {
+		assert.Equal(val, []byte("bar"))
+	}
+
+	val, ttl, ok := m.Get("foo")
+	assert.True(ok)
+	assert.Equal(val, []byte("bar"))
+	assert.Greater(ttl, time.Now().UnixNano())
+
+	// error is not stored.
+	errCompute := errors.New("compute failed")
+	_, err := m.GetOrCompute("err", 0, func() ([]byte, error) {
+		return nil, errCompute
+	})
+	assert.ErrorIs(err, errCompute)
+	_, _, ok = m.Get("err")
+	assert.False(ok)
+
+	// no expiration.
+	_, err = m.GetOrCompute("err", 0, func() ([]byte, error) {
+		return []byte("ok"), nil
+	})
+	assert.Nil(err)
+	_, ttl, ok = m.Get("err")
+	assert.True(ok)
+	assert.Equal(ttl, int64(0))
+
+	// waiters of a panicked compute get an error.
+	computing := make(chan struct{})
+	panicked := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		defer func() {
+			assert.Equal(recover(), "boom")
+			close(panicked)
+		}()
+		m.GetOrCompute("panic", 0, func() ([]byte, error) {
+			close(computing)
+			time.Sleep(50 * time.Millisecond)
+			panic("boom")
+		})
+	}()
+	<-computing
+	go func() {
+		_, err := m.GetOrCompute("panic", 0, func() ([]byte, error) {
+			return []byte("ok"), nil
+		})
+		done <- err
+	}()
+	assert.ErrorIs(<-done, ErrComputePanicked)
+	<-panicked
+	_, _, ok = m.Get("panic")
+	assert.False(ok)
+}
+
+func TestGetOrComputeLeaderWrite(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	computing := make(chan struct{})
+	done := make(chan []byte)
+	go func() {
+		val, _ := m.GetOrCompute("foo", 0, func() ([]byte, error) {
+			close(computing)
+			time.Sleep(50 * time.Millisecond)
+			return []byte("bar"), nil
+		})
+		// the caller running compute writes its result while waiters read theirs.
+		val[0] = 'x'
+		done <- val
+	}()
+	<-computing
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err := m.GetOrCompute("foo", 0, func() ([]byte, error) {
+				return []byte("baz"), nil
+			})
+			assert.Nil(err)
+			assert.Equal(val, []byte("bar"))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(<-done, []byte("xar"))
+}
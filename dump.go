@@ -0,0 +1,281 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+var (
+	errBucketIndex  = errors.New("cache: bucket index out of range")
+	errCorruptEntry = errors.New("cache: corrupt entry")
+)
+
+const (
+	maxDumpEntrySize = 1 << 31 // maxDumpEntrySize is the limit of key and value length of a dumped entry.
+	readChunkSize    = 64 * KB // readChunkSize bounds the buffer growth ahead of data actually read.
+)
+
+// DumpShard writes all alive key-value pairs of the bucket at index to w in binary format.
+// Each entry is written as [key length][value length][ttl][key][value], lengths are uvarint
+// and ttl is varint. Writes on the bucket are blocked during the dump.
+func (c *GigaCache) DumpShard(index int, w io.Writer) error {
+	c.rlock()
+	defer c.runlock()
+	return c.dumpShard(index, w, false, false)
+}
+
+// DumpShardHashed is like DumpShard, but also writes the 128-bit hash of each key before
+// the entry as [hi][lo] in little endian, so that LoadShardHashed skips hashing.
+// Such a dump can only be loaded by a cache with the same ShardCount.
+func (c *GigaCache) DumpShardHashed(index int, w io.Writer) error {
+	c.rlock()
+	defer c.runlock()
+	return c.dumpShard(index, w, true, false)
+}
+
+// entryMeta is the header of entry kept by save, which DumpShard does not write.
+type entryMeta struct {
+	flags   byte   // flags is flagPinned or 0.
+	created int64  // created is the creation time in unix seconds, or 0 if not tracked.
+	version uint64 // version is 0 if not versioned.
+}
+
+func (c *GigaCache) dumpShard(index int, w io.Writer, withHash, withMeta bool) error {
+	if index < 0 || index >= len(c.buckets) {
+		return errBucketIndex
+	}
+	writer := bufio.NewWriter(w)
+	var buf []byte
+	var err error
+
+	bucket := c.buckets[index]
+	bucket.RLock()
+	nanosec := time.Now().UnixNano()
+	bucket.index.All(func(key Key, idx Idx) bool {
+		if idx.expiredWith(nanosec) || bucket.isMiss(idx) {
+			return true
+		}
+		if bucket.corrupted(idx) {
+			return true
+		}
+		_, kstr, value := bucket.findEntry(idx)
+		value, ok := bucket.decode(idx, value)
+		if !ok {
+			return true
+		}
+		buf = buf[:0]
+		if withHash {
+			buf = binary.LittleEndian.AppendUint64(buf, key.Hi)
+			buf = binary.LittleEndian.AppendUint64(buf, key.Lo)
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(kstr)))
+		buf = binary.AppendUvarint(buf, uint64(len(value)))
+		buf = binary.AppendVarint(buf, idx.lo)
+		if withMeta {
+			meta := bucket.meta(idx)
+			buf = append(buf, meta.flags)
+			buf = binary.AppendVarint(buf, meta.created)
+			buf = binary.AppendUvarint(buf, meta.version)
+		}
+		buf = append(buf, kstr...)
+		buf = append(buf, value...)
+		_, err = writer.Write(buf)
+		return err == nil
+	})
+	bucket.RUnlock()
+
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// LoadShard reads key-value pairs in the format of DumpShard into the bucket at index,
+// skipping expired ones. The cache must have the same ShardCount as the dumped one,
+// otherwise an error is returned for keys that belong to another bucket.
+func (c *GigaCache) LoadShard(index int, r io.Reader) error {
+	c.rlock()
+	defer c.runlock()
+	return c.loadShard(index, r, false)
+}
+
+// LoadShardHashed is like LoadShard, but reads the format of DumpShardHashed and uses
+// the stored hashes instead of hashing keys.
+func (c *GigaCache) LoadShardHashed(index int, r io.Reader) error {
+	c.rlock()
+	defer c.runlock()
+	return c.loadShard(index, r, true)
+}
+
+func (c *GigaCache) loadShard(index int, r io.Reader, withHash bool) error {
+	if index < 0 || index >= len(c.buckets) {
+		return errBucketIndex
+	}
+	reader := bufio.NewReader(r)
+	var buf []byte
+
+	bucket := c.buckets[index]
+	bucket.Lock()
+	defer bucket.Unlock()
+
+	for {
+		key, keyStr, value, ttl, _, err := readEntry(reader, &buf, withHash, false, maxDumpEntrySize)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if int(c.shardIndex(key)) != index {
+			return fmt.Errorf("cache: key %q does not belong to bucket %d", keyStr, index)
+		}
+		if ttl == noTTL || ttl > time.Now().UnixNano() {
+			bucket.set(key, keyStr, value, ttl)
+		}
+	}
+}
+
+// readEntry reads an entry in the format of DumpShard, DumpShardHashed or save, the key and
+// value alias buf. It returns io.EOF only if there are no more entries, and errCorruptEntry
+// if the key and value length exceed limit.
+func readEntry(reader *bufio.Reader, buf *[]byte, withHash, withMeta bool, limit uint64) (key Key, keyStr, value []byte, ttl int64, meta entryMeta, err error) {
+	if withHash {
+		var hash [16]byte
+		if _, err = io.ReadFull(reader, hash[:]); err != nil {
+			return
+		}
+		key = Key{Hi: binary.LittleEndian.Uint64(hash[:8]), Lo: binary.LittleEndian.Uint64(hash[8:])}
+	}
+	klen, err := binary.ReadUvarint(reader)
+	if err != nil {
+		if withHash {
+			err = unexpectedEOF(err)
+		}
+		return
+	}
+	vlen, err := binary.ReadUvarint(reader)
+	if err != nil {
+		err = unexpectedEOF(err)
+		return
+	}
+	ttl, err = binary.ReadVarint(reader)
+	if err != nil {
+		err = unexpectedEOF(err)
+		return
+	}
+	if withMeta {
+		if meta.flags, err = reader.ReadByte(); err != nil {
+			err = unexpectedEOF(err)
+			return
+		}
+		if meta.created, err = binary.ReadVarint(reader); err != nil {
+			err = unexpectedEOF(err)
+			return
+		}
+		if meta.version, err = binary.ReadUvarint(reader); err != nil {
+			err = unexpectedEOF(err)
+			return
+		}
+	}
+	// lengths are untrusted, check them without overflow and grow buf only as data arrives.
+	if klen > limit || vlen > limit-klen {
+		err = errCorruptEntry
+		return
+	}
+	n := int(klen + vlen)
+	*buf = (*buf)[:0]
+	for len(*buf) < n {
+		chunk := min(n-len(*buf), readChunkSize)
+		*buf = slices.Grow(*buf, chunk)
+		m, rerr := io.ReadFull(reader, (*buf)[len(*buf):len(*buf)+chunk])
+		*buf = (*buf)[:len(*buf)+m]
+		if rerr != nil {
+			err = unexpectedEOF(rerr)
+			return
+		}
+	}
+	keyStr, value = (*buf)[:klen], (*buf)[klen:]
+	if !withHash {
+		key = hashFn(string(keyStr))
+	}
+	return
+}
+
+// save writes all buckets to path atomically, in the format of DumpShardHashed with the entry
+// header after ttl as [flags][creation time][version], so that keys stored by SetByHash,
+// pinned entries, creation times and versions survive a restart. The flags is a byte,
+// the others are varint.
+func (c *GigaCache) save(path string) error {
+	c.rlock()
+	defer c.runlock()
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	for i := range c.buckets {
+		if err = c.dumpShard(i, f, true, true); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// load reads entries written by save from path, skipping expired ones.
+// Keys are redistributed into buckets by their stored hashes, so ShardCount may differ
+// from the saved cache.
+func (c *GigaCache) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	// an entry can not be larger than the file.
+	limit := min(uint64(info.Size()), maxDumpEntrySize)
+
+	reader := bufio.NewReader(f)
+	var buf []byte
+	for {
+		key, keyStr, value, ttl, meta, err := readEntry(reader, &buf, true, true, limit)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if ttl == noTTL || ttl > time.Now().UnixNano() {
+			bucket := c.buckets[c.shardIndex(key)]
+			bucket.Lock()
+			bucket.restore(key, keyStr, value, ttl, meta)
+			bucket.Unlock()
+		}
+	}
+}
+
+// unexpectedEOF converts io.EOF in the middle of an entry to io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
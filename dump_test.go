@@ -0,0 +1,314 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDumpShard(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 4
+	m1 := New(opt)
+
+	ts := time.Now().Add(time.Hour).UnixNano()
+	for i := 0; i < 1000; i++ {
+		k, v := genKV(i)
+		m1.SetTx(k, v, ts)
+	}
+	m1.Set("foo", []byte{})
+	m1.SetTx("expired", []byte("bar"), time.Now().UnixNano()+int64(10*time.Millisecond))
+
+	dumps := make([]bytes.Buffer, 4)
+	for i := range dumps {
+		assert.Nil(m1.DumpShard(i, &dumps[i]))
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// restore in parallel.
+	m2 := New(opt)
+	done := make(chan error)
+	for i := range dumps {
+		go func(i int) {
+			done <- m2.LoadShard(i, &dumps[i])
+		}(i)
+	}
+	for range dumps {
+		assert.Nil(<-done)
+	}
+
+	assert.Equal(m2.GetStats().Len, 1001)
+	for i := 0; i < 1000; i++ {
+		k, v := genKV(i)
+		val, ttl, ok := m2.Get(k)
+		assert.True(ok)
+		assert.Equal(val, v)
+		assert.Equal(ttl, ts)
+	}
+	val, _, ok := m2.Get("foo")
+	assert.True(ok)
+	assert.Equal(len(val), 0)
+	_, _, ok = m2.Get("expired")
+	assert.False(ok)
+
+	// invalid index.
+	assert.ErrorIs(m1.DumpShard(4, io.Discard), errBucketIndex)
+	assert.ErrorIs(m1.LoadShard(-1, &bytes.Buffer{}), errBucketIndex)
+
+	// wrong shard.
+	var buf bytes.Buffer
+	assert.Nil(m1.DumpShard(0, &buf))
+	assert.ErrorContains(New(opt).LoadShard(1, &buf), "does not belong to bucket 1")
+
+	// truncated.
+	buf.Reset()
+	assert.Nil(m1.DumpShard(0, &buf))
+	assert.ErrorIs(New(opt).LoadShard(0, bytes.NewReader(buf.Bytes()[:buf.Len()-1])), io.ErrUnexpectedEOF)
+
+	// corrupt lengths.
+	for _, lens := range [][2]uint64{{math.MaxUint64, 1}, {math.MaxUint64 / 2, math.MaxUint64 / 2}, {1, maxDumpEntrySize}} {
+		b := binary.AppendUvarint(nil, lens[0])
+		b = binary.AppendUvarint(b, lens[1])
+		b = binary.AppendVarint(b, noTTL)
+		assert.ErrorIs(New(opt).LoadShard(0, bytes.NewReader(b)), errCorruptEntry)
+	}
+	// length larger than the input.
+	b := binary.AppendUvarint(nil, 1<<30)
+	b = binary.AppendUvarint(b, 0)
+	b = binary.AppendVarint(b, noTTL)
+	assert.ErrorIs(New(opt).LoadShard(0, bytes.NewReader(append(b, "foo"...))), io.ErrUnexpectedEOF)
+}
+
+func TestDumpShardHashed(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 4
+	m1 := New(opt)
+
+	for i := 0; i < 1000; i++ {
+		k, v := genKV(i)
+		m1.Set(k, v)
+	}
+
+	m2 := New(opt)
+	for i := 0; i < 4; i++ {
+		var buf bytes.Buffer
+		assert.Nil(m1.DumpShardHashed(i, &buf))
+		assert.Nil(m2.LoadShardHashed(i, &buf))
+	}
+	assert.Equal(m2.GetStats().Len, 1000)
+	for i := 0; i < 1000; i++ {
+		k, v := genKV(i)
+		val, _, ok := m2.Get(k)
+		assert.True(ok)
+		assert.Equal(val, v)
+	}
+
+	// the stored hash is used as is.
+	var buf bytes.Buffer
+	assert.Nil(m1.DumpShardHashed(0, &buf))
+	data := buf.Bytes()
+	data[0] ^= 1
+	m3 := New(opt)
+	assert.Nil(m3.LoadShardHashed(0, bytes.NewReader(data)))
+	assert.Equal(m3.GetStats().Len, m1.GetBucketStats()[0].Len)
+
+	// the first entry is [hash][8][8][0][key][value].
+	key := string(data[19:27])
+	_, _, ok := m3.Get(key)
+	assert.False(ok)
+	hash := hashFn(key)
+	val, _, ok := m3.GetByHash(hash.Hi^1, hash.Lo)
+	assert.True(ok)
+	assert.Equal(string(val), key)
+
+	// truncated.
+	assert.ErrorIs(New(opt).LoadShardHashed(0, bytes.NewReader(data[:10])), io.ErrUnexpectedEOF)
+	assert.ErrorIs(New(opt).LoadShardHashed(0, bytes.NewReader(data[:20])), io.ErrUnexpectedEOF)
+}
+
+func TestPersistPath(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.PersistPath = filepath.Join(t.TempDir(), "cache.db")
+
+	// missing file.
+	m1 := New(opt)
+	assert.Equal(m1.GetStats().Len, 0)
+
+	ts := time.Now().Add(time.Hour).UnixNano()
+	for i := 0; i < 1000; i++ {
+		k, v := genKV(i)
+		m1.SetTx(k, v, ts)
+	}
+	m1.Set("foo", []byte("bar"))
+	m1.SetTx("expired", []byte("bar"), time.Now().Add(-time.Second).UnixNano())
+	assert.Nil(m1.Close())
+
+	// loaded with a different shard count.
+	opt.ShardCount = 16
+	m2 := New(opt)
+	assert.Equal(m2.GetStats().Len, 1001)
+	for i := 0; i < 1000; i++ {
+		k, v := genKV(i)
+		val, ttl, ok := m2.Get(k)
+		assert.True(ok)
+		assert.Equal(val, v)
+		assert.Equal(ttl, ts)
+	}
+
+	// corrupt file.
+	data, err := os.ReadFile(opt.PersistPath)
+	assert.Nil(err)
+	assert.Nil(os.WriteFile(opt.PersistPath, data[:len(data)-1], 0o644))
+	m3 := New(opt)
+	assert.Equal(m3.GetStats().Len, 0)
+
+	// corrupt lengths.
+	for _, lens := range [][2]uint64{{math.MaxUint64, 1}, {math.MaxUint64 / 2, math.MaxUint64 / 2}, {1 << 30, 0}} {
+		b := binary.AppendUvarint(make([]byte, 16), lens[0])
+		b = binary.AppendUvarint(b, lens[1])
+		b = binary.AppendVarint(b, noTTL)
+		b = append(b, 0, 0, 0)
+		assert.Nil(os.WriteFile(opt.PersistPath, append(data, b...), 0o644))
+		assert.NotPanics(func() {
+			assert.Equal(New(opt).GetStats().Len, 0)
+		})
+	}
+}
+
+func TestPersistPathMeta(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 4
+	opt.PersistPath = filepath.Join(t.TempDir(), "cache.db")
+	opt.Versioned = true
+	opt.Immutable = true
+	opt.TrackCreation = true
+	opt.VerifyChecksum = true
+
+	m1 := New(opt)
+	assert.True(m1.SetVersioned("ver", []byte("v5"), 5, 0))
+	assert.True(m1.SetImmutable("pin", []byte("pin")))
+	m1.Set("old", []byte("old"))
+	b, key := m1.getShard("old")
+	idx, _ := b.index.Get(key)
+	b.putCreated(idx, time.Now().Add(-time.Hour).Unix())
+	b.putChecksum(idx)
+	assert.Nil(m1.Close())
+
+	m2 := New(opt)
+	val, version, ok := m2.GetVersioned("ver")
+	assert.True(ok)
+	assert.Equal(val, []byte("v5"))
+	assert.Equal(version, uint64(5))
+	// stale versions are still rejected.
+	assert.False(m2.SetVersioned("ver", []byte("v4"), 4, 0))
+
+	// pinned keys are still write-once.
+	assert.False(m2.SetImmutable("pin", []byte("new")))
+	val, _, _ = m2.Get("pin")
+	assert.Equal(val, []byte("pin"))
+
+	_, age, ok := m2.GetWithAge("old")
+	assert.True(ok)
+	assert.GreaterOrEqual(age, time.Hour)
+	assert.Equal(m2.GetStats().ChecksumFailures, uint64(0))
+
+	// loaded by a cache without the options.
+	opt.Versioned, opt.Immutable, opt.TrackCreation, opt.VerifyChecksum = false, false, false, false
+	m3 := New(opt)
+	val, _, ok = m3.Get("pin")
+	assert.True(ok)
+	assert.Equal(val, []byte("pin"))
+	m3.Set("pin", []byte("new"))
+	val, _, _ = m3.Get("pin")
+	assert.Equal(val, []byte("new"))
+}
+
+func TestByHashIDs(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 4
+	opt.Reshardable = true
+	opt.PersistPath = filepath.Join(t.TempDir(), "cache.db")
+	m := New(opt)
+
+	// unique ids are used as hashes instead of the xxh3 hash of keys.
+	const num = 100
+	ts := time.Now().Add(time.Hour).UnixNano()
+	for i := uint64(0); i < num; i++ {
+		m.SetByHashTx(0, i, fmt.Sprintf("id-%03d", i), []byte{byte(i)}, ts)
+	}
+	check := func(m *GigaCache) {
+		for i := uint64(0); i < num; i++ {
+			val, _, ok := m.GetByHash(0, i)
+			assert.True(ok)
+			assert.Equal(val, []byte{byte(i)})
+		}
+	}
+	check(m)
+
+	// not found by key.
+	_, _, ok := m.Get("id-000")
+	assert.False(ok)
+
+	// found by scans.
+	var keys []string
+	m.ScanSorted(func(key, _ []byte, _ int64) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	assert.Equal(len(keys), num)
+	assert.True(slices.IsSorted(keys))
+	var count int
+	m.ScanByExpiry(num, func([]byte, []byte, int64) bool {
+		count++
+		return true
+	})
+	assert.Equal(count, num)
+
+	// kept by relocations.
+	m.Compact()
+	m.Migrate()
+	assert.Nil(m.Reshard(8))
+	check(m)
+
+	// kept by hashed dumps.
+	for i := range m.buckets {
+		var buf bytes.Buffer
+		assert.Nil(m.DumpShardHashed(i, &buf))
+		assert.Nil(m.LoadShardHashed(i, &buf))
+	}
+	check(m)
+
+	// rehashed by dumps without hashes.
+	opt1 := DefaultOptions
+	opt1.ShardCount = 1
+	m1 := New(opt1)
+	for i := range m.buckets {
+		var buf bytes.Buffer
+		assert.Nil(m.DumpShard(i, &buf))
+		assert.Nil(m1.LoadShard(0, &buf))
+	}
+	_, _, ok = m1.GetByHash(0, 1)
+	assert.False(ok)
+	val, _, ok := m1.Get("id-001")
+	assert.True(ok)
+	assert.Equal(val, []byte{1})
+
+	// kept by PersistPath.
+	assert.Nil(m.Close())
+	opt.ShardCount = 16
+	check(New(opt))
+}
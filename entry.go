@@ -0,0 +1,276 @@
+package cache
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"math/bits"
+	"sync/atomic"
+)
+
+// An entry in bucket data is laid out as:
+//
+//	[key length][value length][flags][creation time][sequence][version][checksum][padding][key][value]
+//
+// lengths are uvarint, flags is present if any flag is enabled by options,
+// creation time is present if TrackCreation is enabled, sequence is present if
+// TrackRecent is enabled, version is present if Versioned is enabled, checksum is
+// present if VerifyChecksum is enabled and covers all other bytes of entry, and padding is present if PadValues is enabled,
+// in which case value length includes the padding.
+const (
+	flagsSize    = 1
+	createdSize  = 4
+	seqSize      = 8
+	versionSize  = 8
+	checksumSize = 4
+	paddingSize  = 4
+
+	minSizeClass = 8
+)
+
+// entry flags.
+const (
+	flagCompressed byte = 1 << iota
+	flagMiss
+	flagPinned
+)
+
+// hasFlags reports whether entries have the flags byte in header.
+func (b *bucket) hasFlags() bool {
+	return b.options.Compressor != nil || b.options.NegativeCache || b.options.Immutable
+}
+
+// isMiss reports whether the entry is a tombstone stored by SetMiss.
+func (b *bucket) isMiss(idx Idx) bool {
+	return b.flags(idx)&flagMiss != 0
+}
+
+// isPinned reports whether the entry is stored by SetImmutable.
+func (b *bucket) isPinned(idx Idx) bool {
+	return b.flags(idx)&flagPinned != 0
+}
+
+// extraSize returns the size of optional fields in entry header.
+func (b *bucket) extraSize() (n int) {
+	if b.hasFlags() {
+		n += flagsSize
+	}
+	if b.options.TrackCreation {
+		n += createdSize
+	}
+	if b.options.TrackRecent {
+		n += seqSize
+	}
+	if b.options.Versioned {
+		n += versionSize
+	}
+	if b.options.VerifyChecksum {
+		n += checksumSize
+	}
+	if b.options.PadValues {
+		n += paddingSize
+	}
+	return
+}
+
+// lengths reads the key and value length of entry at pos, and returns the position of
+// optional fields in entry header. If VerifyChecksum is enabled, a corrupted header is
+// kept inside data, so that it fails the checksum instead of panicking.
+func (b *bucket) lengths(pos int) (klen, vlen uint64, hdr int) {
+	klen, n := binary.Uvarint(b.data[pos:])
+	if !b.options.VerifyChecksum {
+		pos += n
+		vlen, n = binary.Uvarint(b.data[pos:])
+		return klen, vlen, pos + n
+	}
+	// n is 0 if data ends, or negative if the varint overflows.
+	pos += max(n, -n, 1)
+	if pos < len(b.data) {
+		vlen, n = binary.Uvarint(b.data[pos:])
+		pos += max(n, -n, 1)
+	}
+	return klen, vlen, min(pos, len(b.data)-b.extraSize())
+}
+
+// headerPos returns the position of optional fields in entry header.
+func (b *bucket) headerPos(idx Idx) int {
+	_, _, hdr := b.lengths(idx.start())
+	return hdr
+}
+
+// flags returns the flags of entry, or 0 if entries have no flags.
+func (b *bucket) flags(idx Idx) byte {
+	if !b.hasFlags() {
+		return 0
+	}
+	return b.data[b.headerPos(idx)]
+}
+
+// putFlags updates the flags of entry, entries must have flags.
+func (b *bucket) putFlags(idx Idx, flags byte) {
+	b.data[b.headerPos(idx)] = flags
+}
+
+// createdPos returns the position of the creation time in the entry header.
+func (b *bucket) createdPos(idx Idx) int {
+	pos := b.headerPos(idx)
+	if b.hasFlags() {
+		pos += flagsSize
+	}
+	return pos
+}
+
+// created returns the creation time in unix seconds, TrackCreation must be enabled.
+func (b *bucket) created(idx Idx) int64 {
+	pos := b.createdPos(idx)
+	return int64(binary.LittleEndian.Uint32(b.data[pos:]))
+}
+
+// putCreated updates the creation time in unix seconds, TrackCreation must be enabled.
+func (b *bucket) putCreated(idx Idx, sec int64) {
+	pos := b.createdPos(idx)
+	binary.LittleEndian.PutUint32(b.data[pos:], uint32(sec))
+}
+
+// entrySeq is the last sequence number of writes, shared by all caches.
+var entrySeq atomic.Uint64
+
+// seqPos returns the position of the sequence number in the entry header.
+func (b *bucket) seqPos(idx Idx) int {
+	pos := b.createdPos(idx)
+	if b.options.TrackCreation {
+		pos += createdSize
+	}
+	return pos
+}
+
+// seq returns the sequence number of the last write of entry, TrackRecent must be enabled.
+func (b *bucket) seq(idx Idx) uint64 {
+	return binary.LittleEndian.Uint64(b.data[b.seqPos(idx):])
+}
+
+// putSeq updates the sequence number of entry to a new one, TrackRecent must be enabled.
+func (b *bucket) putSeq(idx Idx) {
+	binary.LittleEndian.PutUint64(b.data[b.seqPos(idx):], entrySeq.Add(1))
+}
+
+// versionPos returns the position of the version in the entry header.
+func (b *bucket) versionPos(idx Idx) int {
+	pos := b.seqPos(idx)
+	if b.options.TrackRecent {
+		pos += seqSize
+	}
+	return pos
+}
+
+// version returns the version of entry, Versioned must be enabled.
+func (b *bucket) version(idx Idx) uint64 {
+	return binary.LittleEndian.Uint64(b.data[b.versionPos(idx):])
+}
+
+// putVersion updates the version of entry, Versioned must be enabled.
+func (b *bucket) putVersion(idx Idx, version uint64) {
+	binary.LittleEndian.PutUint64(b.data[b.versionPos(idx):], version)
+}
+
+// meta returns the header of entry kept by save.
+func (b *bucket) meta(idx Idx) (meta entryMeta) {
+	if b.options.Immutable && b.isPinned(idx) {
+		meta.flags = flagPinned
+	}
+	if b.options.TrackCreation {
+		meta.created = b.created(idx)
+	}
+	if b.options.Versioned {
+		meta.version = b.version(idx)
+	}
+	return
+}
+
+// crcTable is the table of checksums of entries.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumOf returns the checksum of entry except the checksum field at off.
+func checksumOf(entry []byte, off int) uint32 {
+	crc := crc32.Checksum(entry[:off], crcTable)
+	return crc32.Update(crc, crcTable, entry[off+checksumSize:])
+}
+
+// checksumPos returns the position of the checksum in the entry header.
+func (b *bucket) checksumPos(idx Idx) int {
+	pos := b.versionPos(idx)
+	if b.options.Versioned {
+		pos += versionSize
+	}
+	return pos
+}
+
+// checksum computes the checksum of entry, VerifyChecksum must be enabled.
+func (b *bucket) checksum(idx Idx) uint32 {
+	entry, _, _ := b.findEntry(idx)
+	return checksumOf(entry, b.checksumPos(idx)-idx.start())
+}
+
+// putChecksum updates the checksum of entry to its current content, VerifyChecksum must be enabled.
+// It must be called after any change of the entry.
+func (b *bucket) putChecksum(idx Idx) {
+	binary.LittleEndian.PutUint32(b.data[b.checksumPos(idx):], b.checksum(idx))
+}
+
+// corrupted reports whether the checksum of entry mismatches its content, and counts the
+// failure. It is always false if VerifyChecksum is disabled.
+func (b *bucket) corrupted(idx Idx) bool {
+	if !b.options.VerifyChecksum {
+		return false
+	}
+	if binary.LittleEndian.Uint32(b.data[b.checksumPos(idx):]) == b.checksum(idx) {
+		return false
+	}
+	b.checksumFailures.Add(1)
+	return true
+}
+
+// sizeClass returns the space reserved for a value of n bytes if PadValues is enabled,
+// which is the next power of two and at least minSizeClass.
+func sizeClass(n int) int {
+	if n <= minSizeClass {
+		return minSizeClass
+	}
+	return 1 << bits.Len(uint(n-1))
+}
+
+// paddingPos returns the position of the padding size in the entry header.
+func (b *bucket) paddingPos(idx Idx) int {
+	return b.headerPos(idx) + b.extraSize() - paddingSize
+}
+
+// padding returns the unused bytes reserved after the value, or 0 if PadValues is disabled.
+func (b *bucket) padding(idx Idx) int {
+	if !b.options.PadValues {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint32(b.data[b.paddingPos(idx):]))
+}
+
+// putPadding updates the padding size of entry, PadValues must be enabled.
+func (b *bucket) putPadding(idx Idx, n int) {
+	binary.LittleEndian.PutUint32(b.data[b.paddingPos(idx):], uint32(n))
+}
+
+// encode compresses the value with Compressor if it gets smaller, and returns the flags.
+func (b *bucket) encode(val []byte) ([]byte, byte) {
+	if c := b.options.Compressor; c != nil {
+		if compressed := c.Compress(val); len(compressed) < len(val) {
+			return compressed, flagCompressed
+		}
+	}
+	return val, 0
+}
+
+// decode returns the original value stored in entry, ok is false if it fails to decompress.
+func (b *bucket) decode(idx Idx, val []byte) ([]byte, bool) {
+	if b.flags(idx)&flagCompressed == 0 {
+		return val, true
+	}
+	val, err := b.options.Compressor.Decompress(val)
+	return val, err == nil
+}
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type flateCompressor struct{}
+
+func (flateCompressor) Compress(src []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	_, _ = w.Write(src)
+	_ = w.Close()
+	return buf.Bytes()
+}
+
+func (flateCompressor) Decompress(src []byte) ([]byte, error) {
+	return io.ReadAll(flate.NewReader(bytes.NewReader(src)))
+}
+
+func TestCompressor(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 1
+	opt.Compressor = flateCompressor{}
+	m := New(opt)
+
+	large := bytes.Repeat([]byte("hello"), 1000)
+	m.Set("large", large)
+	m.Set("small", []byte("bar"))
+
+	stat := m.GetStats()
+	assert.Less(stat.Alloc, uint64(len(large)))
+
+	val, _, ok := m.Get("large")
+	assert.True(ok)
+	assert.Equal(val, large)
+	val, _, ok = m.Get("small")
+	assert.True(ok)
+	assert.Equal(val, []byte("bar"))
+
+	// scan and iterator.
+	m.Scan(func(key, val []byte, _ int64) bool {
+		if string(key) == "large" {
+			assert.Equal(val, large)
+		}
+		return true
+	})
+	it := m.Iterator()
+	for key, val, _, ok := it.Next(); ok; key, val, _, ok = it.Next() {
+		if string(key) == "large" {
+			assert.Equal(val, large)
+		}
+	}
+
+	// update between compressed and uncompressed.
+	m.Set("large", []byte("bar"))
+	val, _, _ = m.Get("large")
+	assert.Equal(val, []byte("bar"))
+	m.Set("small", large)
+	m.Migrate()
+	val, _, _ = m.Get("small")
+	assert.Equal(val, large)
+
+	m.ScanDelete(func(key, val []byte, _ int64) bool {
+		return !bytes.Equal(val, large)
+	})
+	_, _, ok = m.Get("small")
+	assert.False(ok)
+}
@@ -89,16 +89,11 @@ func benchmark(options cache.Options) {
 	// Stat
 	stat := bc.GetStats()
 
-	fmt.Printf("[Cache] %.0fs | %dw | len: %dw | alloc: %v (unused: %.1f%%)\n",
+	fmt.Printf("[Cache] %.0fs | %dw | %v\n",
 		time.Since(start).Seconds(),
 		count/1e4,
-		stat.Len/1e4,
-		formatSize(stat.Alloc),
-		stat.UnusedRate(),
+		stat,
 	)
-	fmt.Printf("[Evict] probe: %vw / %vw (%.1f%%) | mgr: %d\n",
-		stat.Evictions/1e5, stat.Probes/1e5, stat.EvictionRate(),
-		stat.Migrates)
 
 	// mem stats
 	runtime.ReadMemStats(&memStats)
@@ -113,20 +108,3 @@ func benchmark(options cache.Options) {
 
 	fmt.Println("-----------------------------------------------------")
 }
-
-const (
-	KB = 1024
-	MB = 1024 * KB
-)
-
-// formatSize
-func formatSize[T float64 | uint64](size T) string {
-	switch {
-	case size < KB:
-		return fmt.Sprintf("%.0fB", float64(size))
-	case size < MB:
-		return fmt.Sprintf("%.1fKB", float64(size)/KB)
-	default:
-		return fmt.Sprintf("%.1fMB", float64(size)/MB)
-	}
-}
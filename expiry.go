@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	activeExpiryBuckets = 16  // number of buckets sampled per cycle.
+	activeExpiryKeys    = 128 // number of keys probed per bucket per cycle.
+)
+
+// activeExpiry periodically removes expired keys from sampled buckets until Close.
+func (c *GigaCache) activeExpiry(interval time.Duration) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.activeExpiryCycle()
+		}
+	}
+}
+
+// activeExpiryCycle samples a bounded number of keys from random buckets,
+// so that each bucket lock is held only for a short time.
+func (c *GigaCache) activeExpiryCycle() (removed int) {
+	c.rlock()
+	defer c.runlock()
+	for range min(activeExpiryBuckets, len(c.buckets)) {
+		bucket := c.buckets[rand.IntN(len(c.buckets))]
+		bucket.Lock()
+		removed += bucket.sampleExpired(activeExpiryKeys)
+		bucket.Unlock()
+	}
+	return
+}
@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActiveExpiry(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.ShardCount = 4
+	opt.EvictInterval = -1
+	opt.ActiveExpiryInterval = time.Millisecond
+	m := New(opt)
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.SetEx(k, v, time.Millisecond)
+	}
+	m.Set("foo", []byte("bar"))
+
+	assert.Eventually(func() bool {
+		return m.GetStats().Len == 1
+	}, time.Second, time.Millisecond)
+
+	assert.Nil(m.Close())
+	assert.Nil(m.Close())
+
+	// the goroutine would race with the caller on unlocked buckets.
+	opt.ConcurrencySafe = false
+	assert.Panics(func() { New(opt) })
+
+	opt.ConcurrencySafe = true
+	opt.LockKind = LockNone
+	assert.Panics(func() { New(opt) })
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// jsonEntry is the JSON Lines representation of a key-value pair, key and value
+// are encoded in base64 so that binary keys round-trip, and ttl is the expiration
+// timestamp in nanoseconds.
+type jsonEntry struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	TTL   int64  `json:"ttl"`
+}
+
+// ExportJSONL writes all alive key-value pairs to w in JSON Lines format,
+// one object per line like {"key":"Zm9v","value":"YmFy","ttl":0}.
+// Entries are streamed while scanning, so writes on each bucket are blocked during its export.
+func (c *GigaCache) ExportJSONL(w io.Writer) (err error) {
+	encoder := json.NewEncoder(w)
+	c.Scan(func(key, value []byte, ttl int64) bool {
+		err = encoder.Encode(jsonEntry{Key: key, Value: value, TTL: ttl})
+		return err == nil
+	})
+	return
+}
+
+// ImportJSONL reads key-value pairs in the format of ExportJSONL and stores them
+// with their expiration, skipping expired ones. It returns the number of imported entries,
+// or an error with the line number if a line is malformed.
+func (c *GigaCache) ImportJSONL(r io.Reader) (n int, err error) {
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return n, err
+		}
+		eof := err != nil
+
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			var entry jsonEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return n, fmt.Errorf("cache: invalid entry at line %d: %w", line, err)
+			}
+			if entry.TTL == noTTL || entry.TTL > time.Now().UnixNano() {
+				// TTLs were already jittered when stored, do not jitter them again.
+				keyStr := string(entry.Key)
+				c.rlock()
+				bucket, key := c.getShard(keyStr)
+				bucket.Lock()
+				bucket.evictExpiredKeys()
+				bucket.set(key, s2b(&keyStr), entry.Value, entry.TTL)
+				bucket.Unlock()
+				c.runlock()
+				n++
+			}
+		}
+		if eof {
+			return n, nil
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportJSONL(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	ts := time.Now().Add(time.Hour).UnixNano()
+	m.Set("foo", []byte("bar"))
+	m.SetTx("ttl", []byte{0, 1, 255}, ts)
+
+	var buf bytes.Buffer
+	assert.Nil(m.ExportJSONL(&buf))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(len(lines), 2)
+	assert.Contains(lines, `{"key":"Zm9v","value":"YmFy","ttl":0}`)
+	assert.Contains(lines, `{"key":"dHRs","value":"AAH/","ttl":`+fmt.Sprint(ts)+`}`)
+}
+
+func TestImportJSONL(t *testing.T) {
+	assert := assert.New(t)
+	m1 := New(DefaultOptions)
+
+	ts := time.Now().Add(time.Hour).UnixNano()
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m1.SetTx(k, v, ts)
+	}
+	m1.Set("foo", []byte{0, 1, 255})
+	// binary key round-trips.
+	m1.Set("\xff\x00", []byte("bin"))
+
+	var buf bytes.Buffer
+	assert.Nil(m1.ExportJSONL(&buf))
+	// expired entry is skipped.
+	buf.WriteString(`{"key":"ZXhwaXJlZA==","value":"YmFy","ttl":1}`)
+
+	// ttl is imported as is.
+	opt := DefaultOptions
+	opt.TTLJitter = time.Minute
+	m2 := New(opt)
+	n, err := m2.ImportJSONL(&buf)
+	assert.Nil(err)
+	assert.Equal(n, 102)
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		val, ttl, ok := m2.Get(k)
+		assert.True(ok)
+		assert.Equal(val, v)
+		assert.Equal(ttl, ts)
+	}
+	val, _, ok := m2.Get("foo")
+	assert.True(ok)
+	assert.Equal(val, []byte{0, 1, 255})
+	val, _, ok = m2.Get("\xff\x00")
+	assert.True(ok)
+	assert.Equal(val, []byte("bin"))
+	_, _, ok = m2.Get("expired")
+	assert.False(ok)
+
+	// malformed.
+	n, err = m2.ImportJSONL(strings.NewReader("{\"key\":\"YQ==\",\"value\":\"YmFy\",\"ttl\":0}\n\n{bad}\n"))
+	assert.Equal(n, 1)
+	assert.ErrorContains(err, "line 3")
+}
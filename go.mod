@@ -2,17 +2,19 @@ module github.com/xgzlucario/GigaCache
 
 go 1.22
 
-require github.com/stretchr/testify v1.8.4
+require (
+	github.com/cockroachdb/swiss v0.0.0-20240605133600-232b93a2b829
+	github.com/stretchr/testify v1.8.4
+	github.com/zeebo/xxh3 v1.0.2
+)
 
 require (
-	github.com/cockroachdb/swiss v0.0.0-20240605133600-232b93a2b829 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
 	github.com/kr/pretty v0.3.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/rogpeppe/go-internal v1.9.0 // indirect
 	github.com/tidwall/hashmap v1.8.1 // indirect
-	github.com/zeebo/xxh3 v1.0.2 // indirect
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -1,16 +1,19 @@
 package cache
 
 import (
-	"math"
 	"time"
+	"unsafe"
 
+	"github.com/cockroachdb/swiss"
 	"github.com/zeebo/xxh3"
 )
 
 type Key = xxh3.Uint128
 
+// Idx is 16 bytes due to alignment, so the position is stored in 64 bits
+// at no extra cost, and a bucket is not limited to 4GB.
 type Idx struct {
-	hi uint32 // hi is position of data.
+	hi uint64 // hi is position of data.
 	lo int64  // lo is timestamp of key.
 }
 
@@ -31,19 +34,76 @@ func (i Idx) setTTL(ts int64) Idx {
 	return i
 }
 
-func check(x int) {
-	if x > math.MaxUint32 {
-		panic("x overflows the limit of uint32")
-	}
-}
-
 func newIdx(start int, ttl int64) Idx {
-	check(start)
-	return Idx{hi: uint32(start), lo: ttl}
+	return Idx{hi: uint64(start), lo: ttl}
 }
 
 // newIdxx is more efficient than newIdx.
 func newIdxx(start int, idx Idx) Idx {
-	check(start)
-	return Idx{hi: uint32(start), lo: idx.lo}
+	return Idx{hi: uint64(start), lo: idx.lo}
+}
+
+// indexSlotSize is the size of a slot in index, including a control byte of swiss map.
+const indexSlotSize = int(unsafe.Sizeof(Key{}) + unsafe.Sizeof(Idx{}) + 1)
+
+// indexBytes estimates the memory of index with n entries at the max load factor 7/8.
+func indexBytes(n int) uint64 {
+	return uint64(n * indexSlotSize * 8 / 7)
+}
+
+// indexStore maps hashed keys to their storage positions in bucket.
+type indexStore interface {
+	Get(key Key) (Idx, bool)
+	Put(key Key, idx Idx)
+	Delete(key Key)
+	All(yield func(key Key, idx Idx) bool)
+	Len() int
+	Clear()
+}
+
+// IndexKind is the implementation of bucket index.
+type IndexKind uint8
+
+const (
+	IndexSwiss IndexKind = iota // cockroachdb/swiss map.
+	IndexMap                    // builtin map.
+)
+
+func newIndex(kind IndexKind, size int) indexStore {
+	if kind == IndexMap {
+		return make(mapIndex, size)
+	}
+	return swiss.New[Key, Idx](size)
+}
+
+// mapIndex is an indexStore backed by builtin map.
+type mapIndex map[Key]Idx
+
+func (m mapIndex) Get(key Key) (Idx, bool) {
+	idx, ok := m[key]
+	return idx, ok
+}
+
+func (m mapIndex) Put(key Key, idx Idx) {
+	m[key] = idx
+}
+
+func (m mapIndex) Delete(key Key) {
+	delete(m, key)
+}
+
+func (m mapIndex) All(yield func(key Key, idx Idx) bool) {
+	for key, idx := range m {
+		if !yield(key, idx) {
+			return
+		}
+	}
+}
+
+func (m mapIndex) Len() int {
+	return len(m)
+}
+
+func (m mapIndex) Clear() {
+	clear(m)
 }
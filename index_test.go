@@ -21,12 +21,22 @@ func TestIndex(t *testing.T) {
 		assert.Equal(idx.lo, ttl)
 	}
 
-	// panic-start
-	assert.Panics(func() {
-		newIdx(math.MaxUint32+1, 0)
-	})
+	// start beyond 4GB.
+	idx := newIdx(math.MaxUint32+1, 0)
+	assert.Equal(idx.start(), math.MaxUint32+1)
+	assert.Equal(newIdxx(math.MaxUint32+1, Idx{}), idx)
+}
+
+func TestIndexBytes(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(indexSlotSize, 16+16+1)
+	assert.Equal(indexBytes(0), uint64(0))
+	assert.Equal(indexBytes(7), uint64(7*33*8/7))
 
-	assert.Panics(func() {
-		newIdxx(math.MaxUint32+1, Idx{})
-	})
+	m := New(DefaultOptions)
+	for i := 0; i < 1000; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+	assert.Greater(m.GetStats().IndexBytes, uint64(1000*33))
 }
@@ -0,0 +1,41 @@
+//go:build go1.23
+
+package cache
+
+import (
+	"iter"
+	"slices"
+)
+
+// All returns an iterator over all alive key-value pairs for range-over-func.
+// Each bucket is read under its lock and its pairs are copied, then yielded without
+// holding the lock, so the loop body may retain them or write to the cache.
+func (c *GigaCache) All() iter.Seq2[string, []byte] {
+	return func(yield func(string, []byte) bool) {
+		var keys []string
+		var values [][]byte
+		for b := 0; ; b++ {
+			c.rlock()
+			if b >= len(c.buckets) {
+				c.runlock()
+				return
+			}
+			bucket := c.buckets[b]
+			keys, values = keys[:0], values[:0]
+			bucket.RLock()
+			bucket.scan(func(key, value []byte, _ int64) bool {
+				keys = append(keys, string(key))
+				values = append(values, slices.Clone(value))
+				return true
+			})
+			bucket.RUnlock()
+			c.runlock()
+
+			for i, key := range keys {
+				if !yield(key, values[i]) {
+					return
+				}
+			}
+		}
+	}
+}
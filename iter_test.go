@@ -0,0 +1,37 @@
+//go:build go1.23
+
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAll(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+
+	var count int
+	for key, val := range m.All() {
+		assert.Equal(key, string(val))
+		// write during iteration.
+		m.Set(key, []byte("updated"))
+		count++
+	}
+	assert.Equal(count, 100)
+
+	count = 0
+	for range m.All() {
+		count++
+		if count == 10 {
+			break
+		}
+	}
+	assert.Equal(count, 10)
+}
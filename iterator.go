@@ -0,0 +1,92 @@
+package cache
+
+import "time"
+
+// Iterator is a pull-style iterator over alive key-value pairs of GigaCache.
+// It holds the read lock of the bucket being iterated until it advances to the next bucket,
+// so Close must be called if the iteration stops early. Reshard is blocked meanwhile.
+type Iterator struct {
+	cache  *GigaCache
+	bucket int
+	cur    *bucket
+	locked bool
+	closed bool
+	idxs   []Idx
+	pos    int
+}
+
+// Iterator returns a new Iterator over all alive key-value pairs.
+func (c *GigaCache) Iterator() *Iterator {
+	return &Iterator{cache: c, bucket: -1}
+}
+
+// Next returns the next key-value pair, ok is false when the iteration is done.
+// The bytes are not copied and only valid until the next call of Next or Close.
+// DO NOT MODIFY the bytes.
+func (it *Iterator) Next() (key, value []byte, ttl int64, ok bool) {
+	for {
+		if it.locked {
+			b := it.cur
+			for it.pos < len(it.idxs) {
+				idx := it.idxs[it.pos]
+				it.pos++
+				if idx.expired() {
+					continue
+				}
+				_, key, value = b.findEntry(idx)
+				if b.corrupted(idx) {
+					continue
+				}
+				if value, ok = b.decode(idx, value); ok {
+					return key, value, idx.lo, true
+				}
+			}
+			it.unlock()
+		}
+		if it.closed {
+			return nil, nil, 0, false
+		}
+
+		it.cache.rlock()
+		if it.bucket+1 >= len(it.cache.buckets) {
+			it.cache.runlock()
+			it.closed = true
+			return nil, nil, 0, false
+		}
+		it.bucket++
+		it.load()
+	}
+}
+
+// load locks the current bucket and collects its alive indexes, reshardMu must be held.
+func (it *Iterator) load() {
+	b := it.cache.buckets[it.bucket]
+	b.RLock()
+	it.cur = b
+	it.locked = true
+	it.idxs = it.idxs[:0]
+	it.pos = 0
+
+	nanosec := time.Now().UnixNano()
+	b.index.All(func(_ Key, idx Idx) bool {
+		if !idx.expiredWith(nanosec) && !b.isMiss(idx) {
+			it.idxs = append(it.idxs, idx)
+		}
+		return true
+	})
+}
+
+// Close releases the lock held by the iterator, it is safe to call Close more than once.
+func (it *Iterator) Close() {
+	if it.locked {
+		it.unlock()
+	}
+	it.closed = true
+}
+
+// unlock releases the current bucket and reshardMu.
+func (it *Iterator) unlock() {
+	it.cur.RUnlock()
+	it.cache.runlock()
+	it.locked = false
+}
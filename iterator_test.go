@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIterator(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+	m.SetEx("expired", []byte("bar"), time.Millisecond)
+	time.Sleep(time.Millisecond * 10)
+
+	var count int
+	it := m.Iterator()
+	for {
+		key, val, ttl, ok := it.Next()
+		if !ok {
+			break
+		}
+		assert.Equal(key, val)
+		assert.Equal(ttl, int64(0))
+		count++
+	}
+	assert.Equal(count, 100)
+	it.Close()
+
+	// stop early.
+	it = m.Iterator()
+	_, _, _, ok := it.Next()
+	assert.True(ok)
+	it.Close()
+	it.Close()
+	_, _, _, ok = it.Next()
+	assert.False(ok)
+
+	// locks are released.
+	m.Set("foo", []byte("bar"))
+}
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const defaultMetricPrefix = "gigacache"
+
+// expvarMu serializes the check and publish of PublishExpvar.
+var expvarMu sync.Mutex
+
+type metric struct {
+	name  string
+	kind  string
+	help  string
+	value any
+}
+
+// WritePrometheus writes the runtime statistics in Prometheus text exposition format.
+// Metric names are prefixed with Options.MetricPrefix.
+func (c *GigaCache) WritePrometheus(w io.Writer) error {
+	prefix := c.options.MetricPrefix
+	if prefix == "" {
+		prefix = defaultMetricPrefix
+	}
+	stats := c.GetStats()
+	metrics := []metric{
+		{"len", "gauge", "Number of keys in the cache, including expired ones not yet evicted.", stats.Len},
+		{"alloc_bytes", "gauge", "Bytes allocated for the data of all buckets.", stats.Alloc},
+		{"unused_bytes", "gauge", "Bytes of data no longer used and waiting for migration.", stats.Unused},
+		{"migrates_total", "counter", "Total number of bucket migrations.", stats.Migrates},
+		{"evictions_total", "counter", "Total number of evicted keys, expired or to make room.", stats.Evictions},
+		{"expired_evictions_total", "counter", "Total number of evicted expired keys.", stats.ExpiredEvictions},
+		{"capacity_evictions_total", "counter", "Total number of alive keys evicted to make room.", stats.CapacityEvictions},
+		{"probes_total", "counter", "Total number of keys probed by eviction.", stats.Probes},
+	}
+	for _, m := range metrics {
+		name := prefix + "_" + m.name
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, m.help, name, m.kind, name, m.value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PublishExpvar publishes the runtime statistics as expvar under the given name,
+// which makes them visible on /debug/vars. It returns an error if the name is already in use.
+func (c *GigaCache) PublishExpvar(name string) error {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if expvar.Get(name) != nil {
+		return errors.New("cache: expvar name already in use")
+	}
+	expvar.Publish(name, expvar.Func(func() any {
+		return c.GetStats()
+	}))
+	return nil
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+	}
+
+	var buf bytes.Buffer
+	assert.Nil(m.WritePrometheus(&buf))
+	out := buf.String()
+	assert.Contains(out, "# TYPE gigacache_len gauge\ngigacache_len 100\n")
+	assert.Contains(out, "# TYPE gigacache_alloc_bytes gauge\ngigacache_alloc_bytes 1800\n")
+	assert.Contains(out, "# TYPE gigacache_evictions_total counter\n")
+	assert.Contains(out, "# TYPE gigacache_expired_evictions_total counter\ngigacache_expired_evictions_total 0\n")
+	assert.Contains(out, "# TYPE gigacache_capacity_evictions_total counter\ngigacache_capacity_evictions_total 0\n")
+
+	// custom prefix.
+	opt := DefaultOptions
+	opt.MetricPrefix = "myapp_cache"
+	m = New(opt)
+
+	buf.Reset()
+	assert.Nil(m.WritePrometheus(&buf))
+	assert.Contains(buf.String(), "myapp_cache_len 0\n")
+}
+
+func TestPublishExpvar(t *testing.T) {
+	assert := assert.New(t)
+	m := New(DefaultOptions)
+	m.Set("foo", []byte("bar"))
+
+	// expvar names are global, use a unique one for each run.
+	name := fmt.Sprintf("gigacache_test_%d", time.Now().UnixNano())
+
+	var wg sync.WaitGroup
+	var published atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if m.PublishExpvar(name) == nil {
+				published.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(published.Load(), int32(1))
+	assert.NotNil(m.PublishExpvar(name))
+
+	v := expvar.Get(name)
+	assert.NotNil(v)
+	assert.Contains(v.String(), `"Len":1`)
+}
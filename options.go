@@ -1,40 +1,288 @@
 package cache
 
-import "errors"
+import (
+	"errors"
+	"math/bits"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	minShardCount = 16
+	maxShardCount = 4096
+)
 
 // Options is the configuration of GigaCache.
 type Options struct {
 	// ShardCount is shard numbers of cache.
+	// It must be a power of two, or 0 to resolve it automatically based on GOMAXPROCS.
 	ShardCount uint32
 
 	// Default size of the bucket initial.
+	//
+	// Deprecated: IndexSize is the initial index size of each bucket, not of the cache,
+	// use TotalIndexSize instead.
 	IndexSize  int
 	BufferSize int
 
+	// TotalIndexSize is the expected number of keys of the whole cache, which is divided
+	// by ShardCount to size the index of each bucket. It takes precedence over IndexSize.
+	TotalIndexSize int
+
+	// IndexKind specifies the implementation of bucket index.
+	IndexKind IndexKind
+
+	// BufferGrowStep limits the growth of bucket data to a fixed increment
+	// once its capacity reaches the step, instead of doubling.
+	// if n <= 0, data grows by append as usual.
+	BufferGrowStep int
+
+	// MaxEntriesPerBucket limits the number of keys in each bucket, when exceeded,
+	// keys in the bucket are evicted by expiration before inserting a new one.
+	// if n <= 0, it is unlimited.
+	MaxEntriesPerBucket int
+
+	// FullPolicy decides what happens when inserting a new key into a full bucket,
+	// see MaxEntriesPerBucket.
+	FullPolicy FullPolicy
+
+	// EvictionSamples is the number of keys sampled to choose a victim when a bucket is full,
+	// larger value approximates the exact order of expiration better, but costs more.
+	// The samples are the first keys in index order, so they are not random unless
+	// EvictProbeStride is set.
+	EvictionSamples int
+
 	// EvictInterval indicates the frequency of execution of the evict algorithm.
 	// if n >= 0, evict algorithm auto perform every `n` times write.
 	// if n < 0, evict is disabled.
 	EvictInterval int
 
+	// EvictProbeStride makes each pass of the evict algorithm, and each sampling of EvictionSamples
+	// and ActiveExpiryInterval, probe about one in every n keys, chosen by a random seed per pass,
+	// so that passes cover the index evenly regardless of its iteration order, at the cost of
+	// visiting n times more keys. A pass may also miss all keys of a small bucket, so it is
+	// disabled by default.
+	// if n <= 1, keys are probed in index order, which is cheaper but with swiss index keeps
+	// probing a biased subset of keys, so some expired keys are only reclaimed by other paths,
+	// e.g. migration, ActiveExpiryInterval or DeleteExpired.
+	EvictProbeStride int
+
+	// ActiveExpiryInterval is the interval of the background goroutine which samples
+	// buckets and removes expired keys, like the active expiry of Redis.
+	// if d <= 0, it is disabled. The goroutine is stopped by Close.
+	// It requires locked buckets, so it is rejected with LockNone.
+	ActiveExpiryInterval time.Duration
+
+	// LazyExpiryOnGet makes Get evict the key it finds expired, so that reads reclaim
+	// memory in read-heavy workloads. Such a Get takes the write lock of the bucket.
+	LazyExpiryOnGet bool
+
 	// Migrate threshold for a bucket to trigger a migration.
 	MigrateRatio float64
 
+	// ReuseMigrateBuffer keeps the previous data of each bucket after migration and reuses it
+	// as the destination of the next one, so that steady-state migrations do not allocate.
+	// It holds up to twice the memory of data. Values returned with NoValueCopy are
+	// overwritten by a later migration.
+	ReuseMigrateBuffer bool
+
+	// MigrateMinBytes is the minimum unused bytes for a bucket to trigger a migration,
+	// which avoids frequent migrations on small buckets.
+	MigrateMinBytes uint64
+
+	// TTLJitter adds a random offset in [0, TTLJitter) to each expiration,
+	// to avoid a large number of keys expiring at the same time.
+	TTLJitter time.Duration
+
+	// NoValueCopy makes Get return the value aliasing the internal data instead of a copy.
+	// The value must not be modified, and becomes invalid after the next write or
+	// migration on its bucket. Only enable it if values are treated as immutable.
+	NoValueCopy bool
+
+	// BufferPool supplies the buffers leased by GetLease, it must hold values of type *[]byte.
+	// A new buffer is allocated when the pool returns nil. If nil, GetLease allocates a copy
+	// as Get does.
+	BufferPool *sync.Pool
+
+	// Compressor compresses values on write and decompresses them on read,
+	// values are stored compressed only if they get smaller.
+	// Note that reads of compressed values allocate.
+	Compressor Compressor
+
+	// NegativeCache enables SetMiss to store tombstones for keys known to be absent.
+	// It costs 1 extra byte per entry for the flags, unless Compressor is set.
+	NegativeCache bool
+
+	// Immutable enables SetImmutable to store write-once pinned entries, which migration keeps
+	// together in their original order. It costs 1 extra byte per entry for the flags, unless
+	// Compressor or NegativeCache is set.
+	Immutable bool
+
+	// TrackCreation stores the creation time of each entry, see GetWithAge.
+	// It costs 4 extra bytes per entry.
+	TrackCreation bool
+
+	// TrackRecent stores a sequence number of the last write of each entry, see RecentKeys.
+	// It costs 8 extra bytes per entry.
+	TrackRecent bool
+
+	// Versioned stores a version with each entry, see SetVersioned.
+	// It costs 8 extra bytes per entry.
+	Versioned bool
+
+	// PadValues reserves space for values rounded up to the next power of two, so that
+	// values growing by small increments are updated in place instead of reallocated.
+	// It costs 4 extra bytes per entry plus the padding, see Stats.Padding.
+	PadValues bool
+
+	// VerifyKeys compares the stored key with the requested key on read, so that a hash
+	// conflict is treated as not found instead of returning the value of another key.
+	VerifyKeys bool
+
+	// VerifyChecksum stores a CRC of the whole entry, header included, with each entry and
+	// verifies it on read, so that a corrupted entry is treated as not found and is dropped
+	// when the key is set again, see Stats.ChecksumFailures.
+	// It costs 4 extra bytes per entry and a CRC computation on every read and write.
+	VerifyChecksum bool
+
+	// OnHashConflict is called when setting a key whose hash conflicts with a different
+	// alive key, and decides whether to overwrite it. if nil, it is always overwritten.
+	OnHashConflict func(key, conflictKey []byte) (overwrite bool)
+
+	// OnMigrate is called after a bucket migration with the data length before and after.
+	// It runs under the bucket lock, so it must not call back into the cache.
+	OnMigrate func(bucketIndex int, before, after int)
+
+	// OnMigrateDone is called after a bucket migration with the number of entries and bytes
+	// moved and the time it took, excluding callbacks. Compact only counts entries that change
+	// position. It runs under the bucket lock as OnMigrate.
+	OnMigrateDone func(bucketIndex int, entriesMoved, bytesMoved int, took time.Duration)
+
+	// EvictChannel receives a copy of each evicted entry. Sends never block, the
+	// notification is dropped if the channel is full, see Stats.DroppedEvictions.
+	EvictChannel chan<- EvictedEntry
+
+	// PersistPath is the file where Close saves alive keys and New loads them from, if set.
+	// A missing file is ignored, and a corrupt one is logged and the cache starts empty.
+	// Pinned entries, creation times and versions are kept along with the keys.
+	PersistPath string
+
+	// MetricPrefix is the name prefix of metrics exported by WritePrometheus.
+	MetricPrefix string
+
 	// ConcurrencySafe specifies whether RWLocker are required for multithreading safety.
+	// It is an alias of LockRWMutex when LockKind is LockDefault.
 	ConcurrencySafe bool
+
+	// LockKind specifies the lock of buckets, LockMutex is cheaper for write-heavy workloads,
+	// but reads take the full lock.
+	LockKind LockKind
+
+	// TrackLatency records the latencies of Set and Get, see Latencies.
+	// It adds two time.Now calls and an insertion locking the shard's buffer to every call.
+	TrackLatency bool
+
+	// Reshardable enables Reshard. Every call accessing buckets then takes a shared cache-wide
+	// lock, which Reshard takes exclusively, so callbacks passed to the cache and code holding
+	// an open Iterator must not call back into it while a Reshard may run.
+	Reshardable bool
+
+	// TrackLockWait records the time spent acquiring bucket locks, see BucketStats.
+	// It only takes effect when buckets are locked and adds overhead to every lock.
+	TrackLockWait bool
+}
+
+// EvictedEntry is an entry removed by eviction, sent to EvictChannel.
+type EvictedEntry struct {
+	Key   string
+	Value []byte
+	TTL   int64
+}
+
+// Compressor is the interface to compress values, e.g. backed by snappy or zstd.
+type Compressor interface {
+	Compress(src []byte) []byte
+	Decompress(src []byte) ([]byte, error)
 }
 
+// FullPolicy is the behavior of inserting a new key into a full bucket.
+type FullPolicy uint8
+
+const (
+	FullEvict  FullPolicy = iota // evict existing keys to make room.
+	FullReject                   // reject the new key if no expired key can be evicted.
+)
+
+// LockKind is the kind of lock used by buckets.
+type LockKind uint8
+
+const (
+	LockDefault LockKind = iota // LockRWMutex if ConcurrencySafe, otherwise LockNone.
+	LockNone
+	LockMutex
+	LockRWMutex
+)
+
 var DefaultOptions = Options{
 	ShardCount:      1024,
 	IndexSize:       1024,
 	BufferSize:      64 * KB,
 	EvictInterval:   5,
+	EvictionSamples: 5,
 	MigrateRatio:    0.4,
+	MetricPrefix:    "gigacache",
 	ConcurrencySafe: true,
 }
 
+// indexSize returns the initial index size of each bucket, ShardCount must be resolved.
+func (o Options) indexSize() int {
+	if o.TotalIndexSize > 0 {
+		n := int(o.ShardCount)
+		return (o.TotalIndexSize + n - 1) / n
+	}
+	return o.IndexSize
+}
+
+// lockKind resolves the lock of buckets with ConcurrencySafe for backward compatibility.
+func (o Options) lockKind() LockKind {
+	if o.LockKind == LockDefault {
+		if o.ConcurrencySafe {
+			return LockRWMutex
+		}
+		return LockNone
+	}
+	return o.LockKind
+}
+
 func validateOptions(options Options) error {
-	if options.ShardCount == 0 {
-		return errors.New("cache/options: invalid shard count")
+	if n := options.ShardCount; n&(n-1) != 0 {
+		return errors.New("cache/options: shard count must be a power of two")
+	}
+	if options.IndexKind > IndexMap {
+		return errors.New("cache/options: invalid index kind")
+	}
+	if options.FullPolicy > FullReject {
+		return errors.New("cache/options: invalid full policy")
+	}
+	if options.LockKind > LockRWMutex {
+		return errors.New("cache/options: invalid lock kind")
+	}
+	if options.ActiveExpiryInterval > 0 && options.lockKind() == LockNone {
+		return errors.New("cache/options: active expiry requires concurrency safe buckets")
+	}
+	if options.TTLJitter < 0 {
+		return errors.New("cache/options: invalid ttl jitter")
+	}
+	if options.IndexSize < 0 || options.BufferSize < 0 || options.TotalIndexSize < 0 {
+		return errors.New("cache/options: invalid bucket size")
 	}
 	return nil
 }
+
+// autoShardCount returns a power of two near GOMAXPROCS*8, clamped to [minShardCount, maxShardCount].
+func autoShardCount() uint32 {
+	n := uint32(runtime.GOMAXPROCS(0) * 8)
+	n = 1 << bits.Len32(n-1)
+	return min(max(n, minShardCount), maxShardCount)
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"slices"
+	"sync"
+)
+
+const (
+	defaultPercentileWindow = 8192
+	percentileBufferSize    = 16 // percentileBufferSize is the number of samples buffered per shard.
+)
+
+// Percentile records samples in a ring buffer of a fixed window and reports
+// percentiles of the most recent ones. It is safe for concurrent use.
+type Percentile struct {
+	mu   sync.Mutex
+	data []float64
+	pos  int
+}
+
+// NewPercentile creates a Percentile with the default window.
+func NewPercentile() *Percentile {
+	return NewPercentileWindow(defaultPercentileWindow)
+}
+
+// NewPercentileWindow creates a Percentile keeping the most recent size samples,
+// the default window is used if size <= 0.
+func NewPercentileWindow(size int) *Percentile {
+	if size <= 0 {
+		size = defaultPercentileWindow
+	}
+	return &Percentile{data: make([]float64, 0, size)}
+}
+
+// Add records a sample, overwriting the oldest one once the window is full.
+func (p *Percentile) Add(v float64) {
+	p.mu.Lock()
+	p.add(v)
+	p.mu.Unlock()
+}
+
+// addAll records samples in order under a single lock.
+func (p *Percentile) addAll(vs []float64) {
+	p.mu.Lock()
+	for _, v := range vs {
+		p.add(v)
+	}
+	p.mu.Unlock()
+}
+
+func (p *Percentile) add(v float64) {
+	if len(p.data) < cap(p.data) {
+		p.data = append(p.data, v)
+	} else {
+		p.data[p.pos] = v
+		p.pos = (p.pos + 1) % len(p.data)
+	}
+}
+
+// Len returns the number of samples in the window.
+func (p *Percentile) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.data)
+}
+
+// Percentile returns the n-th percentile of samples in the window, n is clamped to [0, 100],
+// so that Percentile(0) is the minimum and Percentile(100) is the maximum.
+// It returns 0 if there are no samples. Each call copies and sorts all samples in the window.
+func (p *Percentile) Percentile(n float64) float64 {
+	p.mu.Lock()
+	data := slices.Clone(p.data)
+	p.mu.Unlock()
+
+	if len(data) == 0 {
+		return 0
+	}
+	slices.Sort(data)
+	i := int(n / 100 * float64(len(data)))
+	return data[min(max(i, 0), len(data)-1)]
+}
+
+// shardedPercentile records samples in a buffer per shard, so that calls on different shards
+// mostly do not contend for the same lock, and flushes them into one shared window when a
+// buffer is full or on read. So the window holds the most recent samples of all shards in
+// proportion to their traffic, and a pending sample is never older than the previous read.
+type shardedPercentile struct {
+	window  *Percentile
+	buffers []percentileBuffer
+}
+
+// percentileBuffer holds samples of a shard not yet flushed into the window.
+type percentileBuffer struct {
+	mu   sync.Mutex
+	data []float64
+}
+
+// newShardedPercentile creates a shardedPercentile with the default window.
+func newShardedPercentile(shards int) *shardedPercentile {
+	s := &shardedPercentile{
+		window:  NewPercentile(),
+		buffers: make([]percentileBuffer, shards),
+	}
+	for i := range s.buffers {
+		s.buffers[i].data = make([]float64, 0, percentileBufferSize)
+	}
+	return s
+}
+
+// add records a sample in the buffer of shard, and flushes the buffer if it is full.
+func (s *shardedPercentile) add(shard int, v float64) {
+	b := &s.buffers[shard%len(s.buffers)]
+	b.mu.Lock()
+	b.data = append(b.data, v)
+	if len(b.data) == cap(b.data) {
+		s.window.addAll(b.data)
+		b.data = b.data[:0]
+	}
+	b.mu.Unlock()
+}
+
+// merge flushes all buffers and returns a copy of the window.
+func (s *shardedPercentile) merge() *Percentile {
+	for i := range s.buffers {
+		b := &s.buffers[i]
+		b.mu.Lock()
+		s.window.addAll(b.data)
+		b.data = b.data[:0]
+		b.mu.Unlock()
+	}
+	p := s.window
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// keep the order of the ring, so that the copy continues overwriting the oldest sample.
+	return &Percentile{data: slices.Clone(p.data), pos: p.pos}
+}
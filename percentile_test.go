@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPercentile(t *testing.T) {
+	assert := assert.New(t)
+	p := NewPercentile()
+	assert.Equal(p.Percentile(50), float64(0))
+
+	for i := 1; i <= 100; i++ {
+		p.Add(float64(i))
+	}
+	assert.Equal(p.Len(), 100)
+	assert.Equal(p.Percentile(50), float64(51))
+	assert.Equal(p.Percentile(99), float64(100))
+
+	// overwrite the oldest samples.
+	for i := 0; i < defaultPercentileWindow; i++ {
+		p.Add(1000)
+	}
+	assert.Equal(p.Len(), defaultPercentileWindow)
+	assert.Equal(p.Percentile(50), float64(1000))
+}
+
+func TestPercentileBounds(t *testing.T) {
+	assert := assert.New(t)
+	p := NewPercentile()
+	for i := 1; i <= 10; i++ {
+		p.Add(float64(i))
+	}
+	assert.Equal(p.Percentile(0), float64(1))
+	assert.Equal(p.Percentile(100), float64(10))
+	assert.Equal(p.Percentile(-1), float64(1))
+	assert.Equal(p.Percentile(200), float64(10))
+}
+
+func TestPercentileWindow(t *testing.T) {
+	assert := assert.New(t)
+	p := NewPercentileWindow(10)
+	for i := 1; i <= 15; i++ {
+		p.Add(float64(i))
+	}
+	// only 6..15 are kept.
+	assert.Equal(p.Len(), 10)
+	assert.Equal(p.Percentile(0), float64(6))
+	assert.Equal(p.Percentile(50), float64(11))
+
+	p = NewPercentileWindow(0)
+	for i := 0; i < defaultPercentileWindow+1; i++ {
+		p.Add(1)
+	}
+	assert.Equal(p.Len(), defaultPercentileWindow)
+}
+
+func TestPercentileConcurrent(t *testing.T) {
+	assert := assert.New(t)
+	p := NewPercentile()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10000; j++ {
+				p.Add(float64(j))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				p.Percentile(99)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(p.Len(), defaultPercentileWindow)
+
+	// concurrent Set and Get with TrackLatency.
+	opt := DefaultOptions
+	opt.TrackLatency = true
+	m := New(opt)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				k, v := genKV(j)
+				m.Set(k, v)
+				m.Get(k)
+			}
+		}()
+	}
+	wg.Wait()
+	set, get := m.Latencies()
+	assert.Equal(set.Len(), 8000)
+	assert.Equal(get.Len(), 8000)
+}
+
+func TestTrackLatency(t *testing.T) {
+	assert := assert.New(t)
+	opt := DefaultOptions
+	opt.TrackLatency = true
+	m := New(opt)
+
+	for i := 0; i < 100; i++ {
+		k, v := genKV(i)
+		m.Set(k, v)
+		m.Get(k)
+	}
+	set, get := m.Latencies()
+	assert.Equal(set.Len(), 100)
+	assert.Equal(get.Len(), 100)
+	assert.Greater(set.Percentile(50), float64(0))
+
+	// samples of all shards share one window in proportion to their traffic.
+	shards := 64
+	s := newShardedPercentile(shards)
+	for i := 0; i < shards; i++ {
+		s.add(i, 1e9) // old samples, one per shard.
+	}
+	assert.Equal(s.merge().Len(), shards)
+	for i := 0; i < 2*defaultPercentileWindow; i++ {
+		s.add(0, float64(i%100)) // recent samples, all on one shard.
+	}
+	p := s.merge()
+	assert.Equal(p.Len(), defaultPercentileWindow)
+	assert.Equal(p.Percentile(0), float64(0))
+	assert.Equal(p.Percentile(100), float64(99))
+	p.Add(1)
+	assert.Equal(s.merge().Len(), defaultPercentileWindow)
+	assert.Equal(newShardedPercentile(4).merge().Len(), 0)
+
+	// disabled.
+	set, get = New(DefaultOptions).Latencies()
+	assert.Nil(set)
+	assert.Nil(get)
+}
@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"slices"
+	"time"
+)
+
+// Snapshot is an immutable point-in-time copy of GigaCache.
+type Snapshot struct {
+	mask    uint32
+	buckets []*bucket
+}
+
+// Snapshot copies all alive key-value pairs into a read-only Snapshot.
+// Each bucket is copied under its own lock, so writes are blocked only per bucket.
+func (c *GigaCache) Snapshot() *Snapshot {
+	c.rlock()
+	defer c.runlock()
+	snap := &Snapshot{
+		mask:    c.mask,
+		buckets: make([]*bucket, len(c.buckets)),
+	}
+	for i, bucket := range c.buckets {
+		bucket.RLock()
+		snap.buckets[i] = bucket.clone()
+		bucket.RUnlock()
+	}
+	return snap
+}
+
+// clone returns a compacted deep copy of the bucket without locking.
+func (b *bucket) clone() *bucket {
+	nb := &bucket{
+		rwlocker: &emptyLocker{},
+		id:       b.id,
+		options:  b.options,
+		index:    newIndex(b.options.IndexKind, b.index.Len()),
+		data:     make([]byte, 0, len(b.data)-int(b.unused)),
+	}
+	nanosec := time.Now().UnixNano()
+	b.index.All(func(key Key, idx Idx) bool {
+		if idx.expiredWith(nanosec) {
+			return true
+		}
+		entry, _, _ := b.findEntry(idx)
+		nb.index.Put(key, newIdxx(len(nb.data), idx))
+		nb.data = append(nb.data, entry...)
+		return true
+	})
+	return nb
+}
+
+// Get retrieves the value and its expiration time for a given key.
+func (s *Snapshot) Get(keyStr string) ([]byte, int64, bool) {
+	key := hashFn(keyStr)
+	bucket := s.buckets[shardOf(key, s.mask)]
+	value, timestamp, found := bucket.get(key, s2b(&keyStr))
+	if found {
+		value = slices.Clone(value)
+	}
+	return value, timestamp, found
+}
+
+// Scan iterates over all alive key-value pairs in the snapshot without copying the data.
+// DO NOT MODIFY the bytes as they are not copied.
+func (s *Snapshot) Scan(callback Walker) {
+	for _, bucket := range s.buckets {
+		if !bucket.scan(callback) {
+			return
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package cache
+
+import (
+	"cmp"
+	"container/heap"
+	"slices"
+	"time"
+)
+
+// maxHeapPrealloc bounds the capacity preallocated for the heaps below, which grow as needed,
+// so that a large n does not allocate upfront for keys that may not exist.
+const maxHeapPrealloc = 1024
+
+// KeySize is a key with the total bytes its entry occupies.
+type KeySize struct {
+	Key  string
+	Size int
+}
+
+// sizeHeap is a min-heap of KeySize by Size.
+type sizeHeap []KeySize
+
+func (h sizeHeap) Len() int           { return len(h) }
+func (h sizeHeap) Less(i, j int) bool { return h[i].Size < h[j].Size }
+func (h sizeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *sizeHeap) Push(x any)        { *h = append(*h, x.(KeySize)) }
+func (h *sizeHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// TopBySize returns the n largest alive entries by size in descending order.
+// It keeps a bounded min-heap during the scan, so memory usage is O(n).
+func (c *GigaCache) TopBySize(n int) []KeySize {
+	c.rlock()
+	defer c.runlock()
+	if n <= 0 {
+		return nil
+	}
+	h := make(sizeHeap, 0, min(n, maxHeapPrealloc))
+	for _, bucket := range c.buckets {
+		bucket.RLock()
+		nanosec := time.Now().UnixNano()
+		bucket.index.All(func(_ Key, idx Idx) bool {
+			if idx.expiredWith(nanosec) || bucket.isMiss(idx) {
+				return true
+			}
+			if bucket.corrupted(idx) {
+				return true
+			}
+			entry, kstr, _ := bucket.findEntry(idx)
+			if len(h) < n {
+				heap.Push(&h, KeySize{Key: string(kstr), Size: len(entry)})
+			} else if len(entry) > h[0].Size {
+				h[0] = KeySize{Key: string(kstr), Size: len(entry)}
+				heap.Fix(&h, 0)
+			}
+			return true
+		})
+		bucket.RUnlock()
+	}
+	slices.SortFunc(h, func(a, b KeySize) int {
+		return b.Size - a.Size
+	})
+	return h
+}
+
+// keyExpiry is a key with its expiration timestamp.
+type keyExpiry struct {
+	key  string
+	hash Key
+	ttl  int64
+}
+
+// expiryHeap is a max-heap of keyExpiry by ttl.
+type expiryHeap []keyExpiry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].ttl > h[j].ttl }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x any)        { *h = append(*h, x.(keyExpiry)) }
+func (h *expiryHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// ScanByExpiry iterates over at most limit alive key-value pairs expiring soonest,
+// in order of expiration. Keys without expiration are excluded.
+// Like ScanSorted, it buffers keys and fetches each value again before calling the Walker,
+// so it is intended for debugging rather than the hot path.
+func (c *GigaCache) ScanByExpiry(limit int, callback Walker) {
+	c.rlock()
+	defer c.runlock()
+	if limit <= 0 {
+		return
+	}
+	h := make(expiryHeap, 0, min(limit, maxHeapPrealloc))
+	for _, bucket := range c.buckets {
+		bucket.RLock()
+		bucket.scanHashed(func(hash Key, key, _ []byte, ttl int64) bool {
+			if ttl == noTTL {
+				return true
+			}
+			if len(h) < limit {
+				heap.Push(&h, keyExpiry{string(key), hash, ttl})
+			} else if ttl < h[0].ttl {
+				h[0] = keyExpiry{string(key), hash, ttl}
+				heap.Fix(&h, 0)
+			}
+			return true
+		})
+		bucket.RUnlock()
+	}
+	slices.SortFunc(h, func(a, b keyExpiry) int {
+		return cmp.Compare(a.ttl, b.ttl)
+	})
+
+	for _, ke := range h {
+		keyStr, key := ke.key, ke.hash
+		bucket := c.buckets[c.shardIndex(key)]
+		bucket.RLock()
+		value, ts, found := bucket.get(key, s2b(&keyStr))
+		continueIteration := true
+		if found {
+			continueIteration = callback(s2b(&keyStr), value, ts)
+		}
+		bucket.RUnlock()
+		if !continueIteration {
+			return
+		}
+	}
+}
+
+// keySeq is a key with the sequence number of its last write.
+type keySeq struct {
+	key string
+	seq uint64
+}
+
+// seqHeap is a min-heap of keySeq by seq.
+type seqHeap []keySeq
+
+func (h seqHeap) Len() int           { return len(h) }
+func (h seqHeap) Less(i, j int) bool { return h[i].seq < h[j].seq }
+func (h seqHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *seqHeap) Push(x any)        { *h = append(*h, x.(keySeq)) }
+func (h *seqHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// RecentKeys returns up to n alive keys most recently set, the most recent first.
+// It returns nil if TrackRecent is disabled.
+func (c *GigaCache) RecentKeys(n int) []string {
+	c.rlock()
+	defer c.runlock()
+	if n <= 0 || !c.options.TrackRecent {
+		return nil
+	}
+	h := make(seqHeap, 0, min(n, maxHeapPrealloc))
+	for _, bucket := range c.buckets {
+		bucket.RLock()
+		nanosec := time.Now().UnixNano()
+		bucket.index.All(func(_ Key, idx Idx) bool {
+			if idx.expiredWith(nanosec) || bucket.isMiss(idx) {
+				return true
+			}
+			seq := bucket.seq(idx)
+			if len(h) == n && seq <= h[0].seq {
+				return true
+			}
+			if bucket.corrupted(idx) {
+				return true
+			}
+			_, kstr, _ := bucket.findEntry(idx)
+			if len(h) < n {
+				heap.Push(&h, keySeq{string(kstr), seq})
+			} else {
+				h[0] = keySeq{string(kstr), seq}
+				heap.Fix(&h, 0)
+			}
+			return true
+		})
+		bucket.RUnlock()
+	}
+	slices.SortFunc(h, func(a, b keySeq) int {
+		return cmp.Compare(b.seq, a.seq)
+	})
+	keys := make([]string, len(h))
+	for i, ks := range h {
+		keys[i] = ks.key
+	}
+	return keys
+}
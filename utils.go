@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"fmt"
 	"math/bits"
 	"unsafe"
 )
@@ -24,3 +25,15 @@ func s2b(str *string) []byte {
 func SizeUvarint(x uint64) int {
 	return int(9*uint32(bits.Len64(x))+64) / 64
 }
+
+// formatSize formats bytes in B, KB or MB.
+func formatSize(size uint64) string {
+	switch {
+	case size < KB:
+		return fmt.Sprintf("%dB", size)
+	case size < KB*KB:
+		return fmt.Sprintf("%.1fKB", float64(size)/KB)
+	default:
+		return fmt.Sprintf("%.1fMB", float64(size)/KB/KB)
+	}
+}